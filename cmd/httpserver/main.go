@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"io"
@@ -9,105 +10,179 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
+	"time"
 
+	"github.com/Jud1k/web_server/internal/certs"
 	"github.com/Jud1k/web_server/internal/headers"
+	"github.com/Jud1k/web_server/internal/middleware"
 	"github.com/Jud1k/web_server/internal/request"
 	"github.com/Jud1k/web_server/internal/response"
+	"github.com/Jud1k/web_server/internal/router"
 	"github.com/Jud1k/web_server/internal/server"
+	"github.com/Jud1k/web_server/internal/websocket"
 )
 
-func handler(w *response.Writer, req *request.Request) {
-	log.Println(string(req.Body))
-	h := response.GetDefaultHeaders(0)
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/") {
-		data := []byte(`Hello, its my implementation HTTP-server from The Mister "I worked in Netflix btw" in boot.dev.
+const (
+	shutdownTimeout    = 30 * time.Second
+	certReloadInterval = 30 * time.Second
+)
+
+func handleRoot(w *response.Writer, req *request.Request) {
+	data := []byte(`Hello, its my implementation HTTP-server from The Mister "I worked in Netflix btw" in boot.dev.
 I realy enjoy do this project. I think go is a awesome language and everyone should try it.
 so if you read this and do not try programming in go, GO do it.`)
-		h.Set("Content-Length", fmt.Sprint(len(data)))
-		h.Set("Content-Type", "text/plain")
-		w.WriteStatusLine(400)
-		w.WriteHeaders(h)
-		w.WriteBody(data)
-	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/html-wrong") {
-		data := []byte(`<html><head><title>400 Bad Request</title></head><body><h1>Bad Request</h1><p>Your request honestly kinda sucked.</p></body></html>`)
-		h.Set("Content-Length", fmt.Sprint(len(data)))
-		h.Set("Content-Type", "text/html")
-		w.WriteStatusLine(400)
-		w.WriteHeaders(h)
-		w.WriteBody(data)
-	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/html-server") {
-		data := []byte(`<html><head><title>500 Internal Server Error</title></head><body><h1>Internal Server Error</h1><p>Okay, you know what? This one is on me.</p></body></html>`)
-		h.Set("Content-Length", fmt.Sprint(len(data)))
-		h.Set("Content-Type", "text/html")
-		w.WriteStatusLine(500)
-		w.WriteHeaders(h)
-		w.WriteBody(data)
+	h := headers.Headers{}
+	h.Set("Content-Type", "text/plain")
+	w.WriteStatusLine(200)
+	w.WriteHeaders(h)
+	w.WriteBody(data)
+}
+
+func handleHTMLWrong(w *response.Writer, req *request.Request) {
+	data := []byte(`<html><head><title>400 Bad Request</title></head><body><h1>Bad Request</h1><p>Your request honestly kinda sucked.</p></body></html>`)
+	h := headers.Headers{}
+	h.Set("Content-Type", "text/html")
+	w.WriteStatusLine(400)
+	w.WriteHeaders(h)
+	w.WriteBody(data)
+}
+
+func handleHTMLServer(w *response.Writer, req *request.Request) {
+	data := []byte(`<html><head><title>500 Internal Server Error</title></head><body><h1>Internal Server Error</h1><p>Okay, you know what? This one is on me.</p></body></html>`)
+	h := headers.Headers{}
+	h.Set("Content-Type", "text/html")
+	w.WriteStatusLine(500)
+	w.WriteHeaders(h)
+	w.WriteBody(data)
+}
+
+func handleHTMLOk(w *response.Writer, req *request.Request) {
+	data := []byte(`<html><head><title>200 OK</title></head><body><h1>Success!</h1><p>Your request was an absolute banger.</p></body></html>`)
+	h := headers.Headers{}
+	h.Set("Content-Type", "text/html")
+	w.WriteStatusLine(200)
+	w.WriteHeaders(h)
+	w.WriteBody(data)
+}
+
+func handleHttpbin(w *response.Writer, req *request.Request) {
+	newTarget := fmt.Sprintf("https://httpbin.org/%s", req.PathValue("path"))
+	if req.Target.RawQuery != "" {
+		newTarget += "?" + req.Target.RawQuery
 	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/html-ok") {
-		data := []byte(`<html><head><title>200 OK</title></head><body><h1>Success!</h1><p>Your request was an absolute banger.</p></body></html>`)
-		h.Set("Content-Length", fmt.Sprint(len(data)))
-		h.Set("Content-Type", "text/html")
-		w.WriteStatusLine(200)
-		w.WriteHeaders(h)
-		w.WriteBody(data)
+	resp, err := http.Get(newTarget)
+	if err != nil {
+		log.Printf("error: %s", err)
+		response.WriteStatus(w, response.StatusCodeBadGateway)
+		return
 	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		newTarget := fmt.Sprintf("https://httpbin.org%s", strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin"))
-		resp, err := http.Get(newTarget)
-		if err != nil {
-			log.Printf("error: %s", err)
-			return
-		}
+	defer resp.Body.Close()
 
-		w.WriteStatusLine(200)
+	w.WriteStatusLine(200)
 
-		h.Del("Content-Length")
-		h.Set("Transfer-Encoding", "chunked")
-		h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
-		trailers := headers.Headers{}
-		w.WriteHeaders(h)
-		fullBody := []byte{}
-		buf := make([]byte, 1024)
-		for {
-			n, err := resp.Body.Read(buf)
-			if n > 0 {
-				_, writeErr := w.WriteChunkedBody(buf[:n])
-				fullBody = append(fullBody, buf[:n]...)
-				if writeErr != nil {
-					break
-				}
+	h := headers.Headers{}
+	h.Set("Content-Type", "text/plain")
+	h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
+	trailers := headers.Headers{}
+	w.WriteHeaders(h)
+	fullBody := []byte{}
+	buf := make([]byte, 1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			_, writeErr := w.WriteBody(buf[:n])
+			fullBody = append(fullBody, buf[:n]...)
+			if writeErr != nil {
+				break
+			}
+			if writeErr = w.Flush(); writeErr != nil {
+				break
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Read error: %s", err)
 			}
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("Read error: %s", err)
-				}
+			break
+		}
+	}
+	bodyHash := sha256.Sum256(fullBody)
+	trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", bodyHash))
+	trailers.Set("X-Content-Length", fmt.Sprint(len(fullBody)))
+	w.WriteTrailers(trailers)
+}
+
+func handleVideo(w *response.Writer, req *request.Request) {
+	fileName := "./assets/vim.mp4"
+	video, err := os.Open(fileName)
+	if err != nil {
+		log.Printf("error: cannot read file with name %s: %s", fileName, err)
+		response.WriteStatus(w, response.StatusCodeNotFound)
+		return
+	}
+	defer video.Close()
+	info, err := video.Stat()
+	if err != nil {
+		log.Printf("error: cannot stat file with name %s: %s", fileName, err)
+		response.WriteStatus(w, response.StatusCodeInternalError)
+		return
+	}
+	h := headers.Headers{}
+	h.Set("Content-Type", "video/mp4")
+	h.Set("Content-Length", fmt.Sprint(info.Size()))
+	w.WriteStatusLine(200)
+	w.WriteHeaders(h)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := video.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.WriteBody(buf[:n]); writeErr != nil {
 				break
 			}
 		}
-		w.WriteChunkedBodyDone()
-		bodyHash := sha256.Sum256(fullBody)
-		trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", bodyHash))
-		trailers.Set("X-Content-Length", fmt.Sprint(len(fullBody)))
-		w.WriteTrailers(trailers)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Read error: %s", err)
+			}
+			break
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{EnableCompression: true}
+
+func handleEcho(w *response.Writer, req *request.Request) {
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Printf("websocket upgrade: %v", err)
+		return
 	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/video") {
-		fileName := "./assets/vim.mp4"
-		video, err := os.ReadFile(fileName)
+	defer conn.Close()
+	for {
+		typ, msg, err := conn.ReadMessage()
 		if err != nil {
-			log.Fatalf("error: cannot read file with name %s", fileName)
+			return
+		}
+		if err := conn.WriteMessage(typ, msg); err != nil {
+			return
 		}
-		h.Set("Content-Type", "video/mp4")
-		h.Set("Content-Length", fmt.Sprint(len(video)))
-		w.WriteStatusLine(200)
-		w.WriteHeaders(h)
-		w.WriteBody(video)
 	}
 }
 
+func newRouter() *router.Router {
+	r := router.New()
+	r.Use(middleware.Recover, middleware.RequestID, middleware.Logging, middleware.Timing)
+	r.Get("/", handleRoot)
+	r.Get("/html-wrong", handleHTMLWrong)
+	r.Get("/html-server", handleHTMLServer)
+	r.Get("/html-ok", handleHTMLOk)
+	r.Get("/httpbin/{path...}", handleHttpbin)
+	r.Get("/video", handleVideo)
+	r.Get("/ws/echo", handleEcho)
+	return r
+}
+
 func main() {
 	port := 8000
 	if len(os.Args) > 1 {
@@ -117,14 +192,37 @@ func main() {
 		}
 		port = arg
 	}
-	server, err := server.Serve(port, handler)
+	var srv *server.Server
+	var err error
+	// httpserver [port] [cert key]...
+	if pairs := os.Args[min(len(os.Args), 2):]; len(pairs) > 0 {
+		if len(pairs)%2 != 0 {
+			log.Fatal("certificates must be given as cert/key pairs")
+		}
+		var keyPairs []certs.KeyPair
+		for i := 0; i < len(pairs); i += 2 {
+			keyPairs = append(keyPairs, certs.KeyPair{CertFile: pairs[i], KeyFile: pairs[i+1]})
+		}
+		store, storeErr := certs.NewStore(keyPairs...)
+		if storeErr != nil {
+			log.Fatal(storeErr)
+		}
+		defer store.Watch(certReloadInterval)()
+		srv, err = server.ServeTLS(port, newRouter().Serve, store)
+	} else {
+		srv, err = server.Serve(port, newRouter().Serve)
+	}
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
 	log.Println("Server gracefully stopped")
 }
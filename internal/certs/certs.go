@@ -0,0 +1,165 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrNoCertificates = errors.New("error: no certificates configured")
+
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Store holds the server certificates and picks one per handshake by SNI.
+// The first key pair is used when the client sends no matching name.
+type Store struct {
+	pairs   []KeyPair
+	mu      sync.RWMutex
+	byName  map[string]*tls.Certificate
+	def     *tls.Certificate
+	modTime map[string]time.Time
+}
+
+func NewStore(pairs ...KeyPair) (*Store, error) {
+	if len(pairs) == 0 {
+		return nil, ErrNoCertificates
+	}
+	s := &Store{pairs: pairs}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads every key pair from disk again. On error the certificates
+// already loaded stay in use.
+func (s *Store) Reload() error {
+	// Read the times first: a file replaced while loading then looks
+	// changed on the next check instead of being missed.
+	modTime := s.currentModTimes()
+	byName := make(map[string]*tls.Certificate)
+	var def *tls.Certificate
+	for _, pair := range s.pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return fmt.Errorf("error: loading %s: %w", pair.CertFile, err)
+		}
+		if def == nil {
+			def = &cert
+		}
+		for _, name := range certNames(cert.Leaf) {
+			if _, ok := byName[name]; !ok {
+				byName[name] = &cert
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byName = byName
+	s.def = def
+	s.modTime = modTime
+	return nil
+}
+
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if cert, ok := s.byName["*."+parent]; ok {
+			return cert, nil
+		}
+	}
+	return s.def, nil
+}
+
+// Watch reloads the certificates on SIGHUP and whenever one of the files
+// changes on disk, checked every interval. Call the returned function to
+// stop watching.
+func (s *Store) Watch(interval time.Duration) func() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer signal.Stop(hup)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+				s.reloadAndLog()
+			case <-ticker.C:
+				if s.changed() {
+					s.reloadAndLog()
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (s *Store) reloadAndLog() {
+	if err := s.Reload(); err != nil {
+		log.Printf("certificate reload failed: %v", err)
+		return
+	}
+	log.Printf("certificates reloaded")
+}
+
+func (s *Store) changed() bool {
+	current := s.currentModTimes()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for file, t := range current {
+		if !t.Equal(s.modTime[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) currentModTimes() map[string]time.Time {
+	modTime := make(map[string]time.Time)
+	for _, pair := range s.pairs {
+		for _, file := range []string{pair.CertFile, pair.KeyFile} {
+			if info, err := os.Stat(file); err == nil {
+				modTime[file] = info.ModTime()
+			}
+		}
+	}
+	return modTime
+}
+
+func certNames(leaf *x509.Certificate) []string {
+	if leaf == nil {
+		return nil
+	}
+	if len(leaf.DNSNames) > 0 {
+		names := make([]string, len(leaf.DNSNames))
+		for i, name := range leaf.DNSNames {
+			names[i] = strings.ToLower(name)
+		}
+		return names
+	}
+	if leaf.Subject.CommonName != "" {
+		return []string{strings.ToLower(leaf.Subject.CommonName)}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jud1k/web_server/internal/certs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeyPair(t *testing.T, dir, name string, dnsNames ...string) certs.KeyPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	pair := certs.KeyPair{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return pair
+}
+
+func serialFor(t *testing.T, store *certs.Store, serverName string) *big.Int {
+	t.Helper()
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	require.NoError(t, err)
+	require.NotNil(t, cert.Leaf)
+	return cert.Leaf.SerialNumber
+}
+
+func TestStoreSelectsBySNI(t *testing.T) {
+	dir := t.TempDir()
+	first := writeKeyPair(t, dir, "first", "example.com", "www.example.com")
+	second := writeKeyPair(t, dir, "second", "api.internal", "*.apps.internal")
+	store, err := certs.NewStore(first, second)
+	require.NoError(t, err)
+
+	firstSerial := serialFor(t, store, "example.com")
+	secondSerial := serialFor(t, store, "api.internal")
+	assert.NotEqual(t, firstSerial, secondSerial)
+	assert.Equal(t, firstSerial, serialFor(t, store, "WWW.Example.com."))
+	assert.Equal(t, secondSerial, serialFor(t, store, "dash.apps.internal"))
+	assert.Equal(t, firstSerial, serialFor(t, store, "unknown.test"))
+	assert.Equal(t, firstSerial, serialFor(t, store, ""))
+}
+
+func TestStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	pair := writeKeyPair(t, dir, "site", "example.com")
+	store, err := certs.NewStore(pair)
+	require.NoError(t, err)
+	before := serialFor(t, store, "example.com")
+
+	writeKeyPair(t, dir, "site", "example.com")
+	require.NoError(t, store.Reload())
+	assert.NotEqual(t, before, serialFor(t, store, "example.com"))
+}
+
+func TestStoreKeepsCertificatesOnFailedReload(t *testing.T) {
+	dir := t.TempDir()
+	pair := writeKeyPair(t, dir, "site", "example.com")
+	store, err := certs.NewStore(pair)
+	require.NoError(t, err)
+	before := serialFor(t, store, "example.com")
+
+	require.NoError(t, os.WriteFile(pair.KeyFile, []byte("garbage"), 0o600))
+	assert.Error(t, store.Reload())
+	assert.Equal(t, before, serialFor(t, store, "example.com"))
+}
+
+func TestStoreWatchReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	pair := writeKeyPair(t, dir, "site", "example.com")
+	store, err := certs.NewStore(pair)
+	require.NoError(t, err)
+	before := serialFor(t, store, "example.com")
+
+	stop := store.Watch(10 * time.Millisecond)
+	defer stop()
+	writeKeyPair(t, dir, "site", "example.com")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(pair.CertFile, future, future))
+
+	assert.Eventually(t, func() bool {
+		return serialFor(t, store, "example.com").Cmp(before) != 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestNewStoreRequiresCertificates(t *testing.T) {
+	_, err := certs.NewStore()
+	assert.ErrorIs(t, err, certs.ErrNoCertificates)
+}
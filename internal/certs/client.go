@@ -0,0 +1,49 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrNoClientCAs = errors.New("error: client authentication needs a CA pool")
+
+// ClientAuth selects how a TLS server treats client certificates.
+type ClientAuth int
+
+const (
+	ClientAuthNone ClientAuth = iota
+	// ClientAuthOptional verifies a certificate when the client sends one.
+	ClientAuthOptional
+	// ClientAuthRequired rejects handshakes without a valid certificate.
+	ClientAuthRequired
+)
+
+func (a ClientAuth) TLSType() tls.ClientAuthType {
+	switch a {
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven
+	case ClientAuthRequired:
+		return tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert
+	}
+}
+
+// LoadCertPool builds a pool from PEM files holding one or more CA
+// certificates.
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("error: no certificates found in %s", file)
+		}
+	}
+	return pool, nil
+}
@@ -0,0 +1,63 @@
+package cookie_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jud1k/web_server/internal/cookie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cookies := cookie.Parse(`session=abc123; theme="dark"; bad name=x; empty=; noequals`)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "theme", cookies[1].Name)
+	assert.Equal(t, "dark", cookies[1].Value)
+	assert.Equal(t, "empty", cookies[2].Name)
+	assert.Equal(t, "", cookies[2].Value)
+}
+
+func TestString(t *testing.T) {
+	c := &cookie.Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    cookie.SameSiteNone,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Sun, 18 Oct 2026 10:00:00 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=None; Partitioned", c.String())
+
+	deleted := &cookie.Cookie{Name: "session", MaxAge: -1, SameSite: cookie.SameSiteLax}
+	assert.Equal(t, "session=; Max-Age=0; SameSite=Lax", deleted.String())
+
+	quoted := &cookie.Cookie{Name: "greeting", Value: "hello, world"}
+	assert.Equal(t, `greeting="hello, world"`, quoted.String())
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		cookie cookie.Cookie
+		err    error
+	}{
+		{cookie.Cookie{Name: ""}, cookie.ErrInvalidName},
+		{cookie.Cookie{Name: "a;b"}, cookie.ErrInvalidName},
+		{cookie.Cookie{Name: "a", Value: "x;y"}, cookie.ErrInvalidValue},
+		{cookie.Cookie{Name: "a", Value: `x"y`}, cookie.ErrInvalidValue},
+		{cookie.Cookie{Name: "a", Domain: "bad_domain.com"}, cookie.ErrInvalidDomain},
+		{cookie.Cookie{Name: "a", Path: "/x;y"}, cookie.ErrInvalidPath},
+		{cookie.Cookie{Name: "a", SameSite: cookie.SameSiteNone}, cookie.ErrInsecure},
+		{cookie.Cookie{Name: "a", Partitioned: true}, cookie.ErrInsecure},
+	}
+	for _, tt := range tests {
+		assert.ErrorIs(t, tt.cookie.Valid(), tt.err)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"errors"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
-type Headers map[string]string
+// Headers holds field values per name in the order they were received.
+type Headers map[string][]string
 
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	if bytes.HasPrefix(data, []byte("\r\n")) {
@@ -22,7 +24,7 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	if len(headerParts) != 2 || strings.HasSuffix(headerParts[0], " ") {
 		return 0, false, errors.New("error: Invalid format header")
 	}
-	headerName := strings.ToLower(headerParts[0])
+	headerName := headerParts[0]
 	headerVal := headerParts[1]
 	matched, err := regexp.Match(`^[A-Za-z0-9!#$%&'*+\-.\^_|~]+$`, []byte(headerName))
 	if err != nil {
@@ -36,22 +38,88 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 }
 
 func (h Headers) Add(key, val string) {
-	if _, ok := h[key]; ok == true {
-		h[key] += ", " + val
-	} else {
-		h[key] = val
-	}
+	key = CanonicalKey(key)
+	h[key] = append(h[key], val)
 }
 
 func (h Headers) Set(key, val string) {
-	h[key] = val
+	h[CanonicalKey(key)] = []string{val}
 }
 
+// Get returns the field value, combining repeated fields into one
+// comma-separated value.
 func (h Headers) Get(key string) string {
-	lowerKey := strings.ToLower(key)
-	return h[lowerKey]
+	return strings.Join(h[CanonicalKey(key)], ", ")
+}
+
+func (h Headers) Values(key string) []string {
+	return h[CanonicalKey(key)]
+}
+
+func (h Headers) Has(key string) bool {
+	_, ok := h[CanonicalKey(key)]
+	return ok
 }
 
 func (h Headers) Del(key string) {
-	delete(h, key)
+	delete(h, CanonicalKey(key))
+}
+
+// HasToken reports whether the comma-separated values of key contain
+// token, compared case-insensitively, as for Connection or Upgrade.
+func (h Headers) HasToken(key, token string) bool {
+	for _, val := range h.Values(key) {
+		for _, part := range strings.Split(val, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanonicalKey returns the canonical form of a field name: the first letter
+// and every letter following a hyphen upper case, the rest lower case, so
+// "content-type" becomes "Content-Type". Names containing spaces or other
+// invalid characters are returned unchanged.
+func CanonicalKey(key string) string {
+	upper := true
+	b := []byte(key)
+	for i, c := range b {
+		if c == ' ' || c >= 0x7f || c < 0x21 {
+			return key
+		}
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+// Combinable reports whether repeated fields with this name may be joined
+// into a single comma-separated line. Set-Cookie values may contain commas
+// themselves, so each one has to stay on its own line.
+func Combinable(key string) bool {
+	switch strings.ToLower(key) {
+	case "set-cookie", "www-authenticate", "proxy-authenticate":
+		return false
+	default:
+		return true
+	}
+}
+
+// ParseContentLength parses a Content-Length value, which must be 1*DIGIT:
+// no sign, spaces or list of values.
+func ParseContentLength(s string) (int64, bool) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
 }
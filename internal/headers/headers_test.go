@@ -14,7 +14,7 @@ func TestParseHeadersSuccess(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 }
@@ -34,12 +34,12 @@ func TestParseHeadersWithOneExisting(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, len(data[:n]), n)
 	assert.False(t, done)
 
 	_, _, err = headers.Parse(data[n:])
-	assert.Equal(t, "localhost:42069, localhost:12345", headers["host"])
+	assert.Equal(t, "localhost:42069, localhost:12345", headers.Get("host"))
 	assert.Equal(t, len(data[n:])-2, n)
 	assert.False(t, done)
 }
@@ -59,7 +59,74 @@ func TestParseHeadersNotCrlfToBody(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, len(data), n)
 	assert.False(t, done)
 }
+
+func TestAddKeepsValuesInOrder(t *testing.T) {
+	headers := headers.Headers{}
+	data := []byte("set-cookie: a=1; Path=/\r\nset-cookie: b=2, c\r\nset-cookie: d=4\r\n\r\n")
+	for {
+		n, done, err := headers.Parse(data)
+		require.NoError(t, err)
+		data = data[n:]
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a=1; Path=/", "b=2, c", "d=4"}, headers.Values("set-cookie"))
+}
+
+func TestCombinable(t *testing.T) {
+	assert.True(t, headers.Combinable("Accept"))
+	assert.False(t, headers.Combinable("Set-Cookie"))
+}
+
+func TestCaseInsensitiveAccess(t *testing.T) {
+	h := headers.Headers{}
+	h.Set("content-length", "10")
+	h.Set("Content-Length", "20")
+	h.Add("CONTENT-TYPE", "text/plain")
+	assert.Len(t, h, 2)
+	assert.Equal(t, "20", h.Get("CONTENT-length"))
+	assert.Equal(t, []string{"text/plain"}, h["Content-Type"])
+	assert.True(t, h.Has("content-type"))
+
+	h.Del("content-TYPE")
+	assert.False(t, h.Has("Content-Type"))
+}
+
+func TestParseStoresCanonicalNames(t *testing.T) {
+	h := headers.Headers{}
+	_, _, err := h.Parse([]byte("x-forwarded-FOR: 10.0.0.1\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, h["X-Forwarded-For"])
+}
+
+func TestCanonicalKey(t *testing.T) {
+	assert.Equal(t, "Content-Type", headers.CanonicalKey("content-type"))
+	assert.Equal(t, "X-Request-Id", headers.CanonicalKey("X-REQUEST-ID"))
+	assert.Equal(t, "Host", headers.CanonicalKey("host"))
+	assert.Equal(t, "bad key", headers.CanonicalKey("bad key"))
+}
+
+func TestHasToken(t *testing.T) {
+	h := headers.Headers{}
+	h.Add("Connection", "keep-alive, Upgrade")
+	h.Add("connection", "X-Custom")
+	assert.True(t, h.HasToken("Connection", "upgrade"))
+	assert.True(t, h.HasToken("connection", "x-custom"))
+	assert.False(t, h.HasToken("Connection", "close"))
+	assert.False(t, h.HasToken("Upgrade", "websocket"))
+}
+
+func TestParseContentLength(t *testing.T) {
+	n, ok := headers.ParseContentLength("042")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), n)
+	for _, value := range []string{"", "+3", "-3", " 3", "3 ", "3, 3", "0x3", "99999999999999999999"} {
+		_, ok := headers.ParseContentLength(value)
+		assert.False(t, ok, "%q", value)
+	}
+}
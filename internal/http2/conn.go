@@ -0,0 +1,719 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/Jud1k/web_server/internal/netutil"
+	"github.com/Jud1k/web_server/internal/request"
+	"github.com/Jud1k/web_server/internal/response"
+)
+
+// ClientPreface starts every HTTP/2 connection. On cleartext connections
+// it doubles as the signal that the client wants h2c with prior knowledge.
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	// NextProto is the ALPN protocol ID of HTTP/2 over TLS.
+	NextProto = "h2"
+
+	defaultMaxStreams   = 250
+	initialRecvWindow   = 1 << 20
+	windowUpdateTrigger = 16 << 10
+)
+
+var (
+	errBadPreface   = errors.New("error: invalid HTTP/2 client preface")
+	errStreamClosed = errors.New("error: http2 stream closed")
+	errConnClosed   = errors.New("error: http2 connection closed")
+)
+
+type Handler func(w *response.Writer, req *request.Request)
+
+type Options struct {
+	MaxConcurrentStreams uint32
+	Limits               request.Limits
+	IdleTimeout          time.Duration
+	WriteTimeout         time.Duration
+	TLS                  *tls.ConnectionState
+}
+
+// Conn serves HTTP/2 on one connection. Frames are read on the goroutine
+// calling Serve and every stream runs its handler on its own goroutine;
+// writes from all of them share bw under wmu.
+type Conn struct {
+	nc      net.Conn
+	br      *bufio.Reader
+	handler Handler
+	opts    Options
+	dec     *decoder
+
+	wmu sync.Mutex
+	bw  *bufio.Writer
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	streams map[uint32]*stream
+	// running counts handlers that haven't returned, including those of
+	// streams the client already reset, so that RST_STREAM can't be used
+	// to get around MaxConcurrentStreams.
+	running       int
+	lastStreamID  uint32
+	sendWindow    int64
+	peerWindow    int64
+	peerMaxFrame  uint32
+	recvWindow    int64
+	pendingUpdate int64
+	goingAway     bool
+	closed        bool
+	wg            sync.WaitGroup
+
+	// Header block being assembled from HEADERS and CONTINUATION frames.
+	blockStream    uint32
+	block          []byte
+	blockEndStream bool
+	blockSelfDep   bool
+}
+
+// NewConn prepares to serve HTTP/2 on nc. rd must yield everything the
+// client sent, starting with the preface; it usually wraps nc.
+func NewConn(nc net.Conn, rd io.Reader, handler Handler, opts Options) *Conn {
+	if opts.MaxConcurrentStreams == 0 {
+		opts.MaxConcurrentStreams = defaultMaxStreams
+	}
+	opts.Limits = opts.Limits.WithDefaults()
+	c := &Conn{
+		nc:           nc,
+		br:           bufio.NewReaderSize(rd, 32<<10),
+		handler:      handler,
+		opts:         opts,
+		dec:          newDecoder(defaultTableSize, opts.Limits.MaxHeaderBytes),
+		bw:           bufio.NewWriterSize(nc, 32<<10),
+		streams:      make(map[uint32]*stream),
+		sendWindow:   defaultWindowSize,
+		peerWindow:   defaultWindowSize,
+		peerMaxFrame: defaultMaxFrame,
+		recvWindow:   initialRecvWindow,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Serve runs the connection until the client goes away, a connection
+// error occurs or Shutdown finishes, and waits for all handlers.
+func (c *Conn) Serve() error {
+	defer c.close()
+	// Whatever deadlines the caller used for the TLS handshake or the
+	// first bytes no longer apply; writes only time out per WriteTimeout.
+	c.nc.SetWriteDeadline(time.Time{})
+	c.nc.SetReadDeadline(netutil.Deadline(time.Now(), c.opts.IdleTimeout))
+	var preface [len(ClientPreface)]byte
+	if _, err := io.ReadFull(c.br, preface[:]); err != nil {
+		return err
+	}
+	if string(preface[:]) != ClientPreface {
+		return errBadPreface
+	}
+	settings := appendSettings(nil,
+		setting{settingMaxConcurrentStreams, c.opts.MaxConcurrentStreams},
+		setting{settingInitialWindowSize, initialRecvWindow},
+		setting{settingMaxHeaderListSize, uint32(c.opts.Limits.MaxHeaderBytes)},
+	)
+	if err := c.writeControl(frameSettings, 0, 0, settings); err != nil {
+		return err
+	}
+	if err := c.writeWindowUpdate(0, initialRecvWindow-defaultWindowSize); err != nil {
+		return err
+	}
+
+	var buf []byte
+	first := true
+	for {
+		f, next, err := readFrame(c.br, buf, defaultMaxFrame)
+		buf = next
+		if err == nil && first && (f.typ != frameSettings || f.has(flagAck)) {
+			err = ConnError{ErrCodeProtocol, "connection must start with SETTINGS"}
+		}
+		first = false
+		if err == nil {
+			err = c.processFrame(f)
+		}
+		var streamErr StreamError
+		if errors.As(err, &streamErr) {
+			c.resetStream(streamErr.StreamID, streamErr.Code)
+			continue
+		}
+		if err != nil {
+			return c.fail(err)
+		}
+	}
+}
+
+// Shutdown sends GOAWAY so the client opens no new streams, then closes
+// the connection once the streams in flight have finished.
+func (c *Conn) Shutdown() {
+	c.mu.Lock()
+	if c.goingAway || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.goingAway = true
+	last := c.lastStreamID
+	idle := len(c.streams) == 0
+	c.mu.Unlock()
+	c.writeGoAway(last, ErrCodeNo)
+	if idle {
+		c.nc.Close()
+	}
+}
+
+func (c *Conn) fail(err error) error {
+	var connErr ConnError
+	if errors.As(err, &connErr) {
+		c.mu.Lock()
+		last := c.lastStreamID
+		c.mu.Unlock()
+		c.writeGoAway(last, connErr.Code)
+		return err
+	}
+	if netutil.IsTimeout(err) {
+		c.mu.Lock()
+		idle := len(c.streams) == 0
+		last := c.lastStreamID
+		c.mu.Unlock()
+		if idle {
+			c.writeGoAway(last, ErrCodeNo)
+			return nil
+		}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (c *Conn) close() {
+	c.mu.Lock()
+	c.closed = true
+	for _, st := range c.streams {
+		st.reset = true
+		st.body.fail(errConnClosed)
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.nc.Close()
+	c.wg.Wait()
+}
+
+func (c *Conn) processFrame(f frame) error {
+	if c.blockStream != 0 && (f.typ != frameContinuation || f.streamID != c.blockStream) {
+		return ConnError{ErrCodeProtocol, "expected CONTINUATION"}
+	}
+	switch f.typ {
+	case frameData:
+		return c.processData(f)
+	case frameHeaders:
+		return c.processHeaders(f)
+	case frameContinuation:
+		return c.processContinuation(f)
+	case framePriority:
+		if f.streamID == 0 {
+			return ConnError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(f.payload) != priorityPayloadLen {
+			return StreamError{f.streamID, ErrCodeFrameSize, "PRIORITY payload must be 5 bytes"}
+		}
+		return nil
+	case frameRSTStream:
+		return c.processRSTStream(f)
+	case frameSettings:
+		return c.processSettings(f)
+	case framePushPromise:
+		return ConnError{ErrCodeProtocol, "clients cannot push"}
+	case framePing:
+		return c.processPing(f)
+	case frameGoAway:
+		if f.streamID != 0 {
+			return ConnError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+		if len(f.payload) < goAwayMinLen {
+			return ConnError{ErrCodeFrameSize, "short GOAWAY"}
+		}
+		c.mu.Lock()
+		c.goingAway = true
+		c.mu.Unlock()
+		return nil
+	case frameWindowUpdate:
+		return c.processWindowUpdate(f)
+	default:
+		// Unknown frame types must be ignored.
+		return nil
+	}
+}
+
+func (c *Conn) processSettings(f frame) error {
+	if f.streamID != 0 {
+		return ConnError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if f.has(flagAck) {
+		if len(f.payload) != 0 {
+			return ConnError{ErrCodeFrameSize, "SETTINGS ack with payload"}
+		}
+		return nil
+	}
+	settings, err := parseSettings(f.payload)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	for _, s := range settings {
+		switch s.id {
+		case settingInitialWindowSize:
+			delta := int64(s.value) - c.peerWindow
+			for _, st := range c.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					c.mu.Unlock()
+					return ConnError{ErrCodeFlowControl, "stream window overflow"}
+				}
+			}
+			c.peerWindow = int64(s.value)
+		case settingMaxFrameSize:
+			c.peerMaxFrame = s.value
+		}
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return c.writeControl(frameSettings, flagAck, 0, nil)
+}
+
+func (c *Conn) processPing(f frame) error {
+	if f.streamID != 0 {
+		return ConnError{ErrCodeProtocol, "PING on a stream"}
+	}
+	if len(f.payload) != pingPayloadLen {
+		return ConnError{ErrCodeFrameSize, "PING payload must be 8 bytes"}
+	}
+	if f.has(flagAck) {
+		return nil
+	}
+	return c.writeControl(framePing, flagAck, 0, f.payload)
+}
+
+func (c *Conn) processWindowUpdate(f frame) error {
+	if len(f.payload) != windowUpdateLen {
+		return ConnError{ErrCodeFrameSize, "WINDOW_UPDATE payload must be 4 bytes"}
+	}
+	inc := int64(binary.BigEndian.Uint32(f.payload) & streamIDMask)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.streamID == 0 {
+		if inc == 0 {
+			return ConnError{ErrCodeProtocol, "zero WINDOW_UPDATE increment"}
+		}
+		c.sendWindow += inc
+		if c.sendWindow > maxWindowSize {
+			return ConnError{ErrCodeFlowControl, "connection window overflow"}
+		}
+		c.cond.Broadcast()
+		return nil
+	}
+	if f.streamID > c.lastStreamID {
+		return ConnError{ErrCodeProtocol, "WINDOW_UPDATE on idle stream"}
+	}
+	st := c.streams[f.streamID]
+	if st == nil {
+		return nil
+	}
+	if inc == 0 {
+		return StreamError{f.streamID, ErrCodeProtocol, "zero WINDOW_UPDATE increment"}
+	}
+	st.sendWindow += inc
+	if st.sendWindow > maxWindowSize {
+		return StreamError{f.streamID, ErrCodeFlowControl, "stream window overflow"}
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *Conn) processRSTStream(f frame) error {
+	if f.streamID == 0 {
+		return ConnError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if len(f.payload) != rstStreamLen {
+		return ConnError{ErrCodeFrameSize, "RST_STREAM payload must be 4 bytes"}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.streamID > c.lastStreamID {
+		return ConnError{ErrCodeProtocol, "RST_STREAM on idle stream"}
+	}
+	if st := c.streams[f.streamID]; st != nil {
+		st.reset = true
+		st.body.fail(errStreamClosed)
+		c.removeLocked(st)
+		c.cond.Broadcast()
+	}
+	return nil
+}
+
+func (c *Conn) processData(f frame) error {
+	if f.streamID == 0 {
+		return ConnError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+	data, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	size := int64(len(f.payload))
+	c.mu.Lock()
+	if f.streamID > c.lastStreamID {
+		c.mu.Unlock()
+		return ConnError{ErrCodeProtocol, "DATA on idle stream"}
+	}
+	if size > c.recvWindow {
+		c.mu.Unlock()
+		return ConnError{ErrCodeFlowControl, "connection window exceeded"}
+	}
+	c.recvWindow -= size
+	st := c.streams[f.streamID]
+	if st == nil {
+		// Most likely sent before the client saw our RST_STREAM.
+		c.mu.Unlock()
+		c.returnWindow(nil, size)
+		return nil
+	}
+	if st.remoteClosed {
+		c.mu.Unlock()
+		c.returnWindow(nil, size)
+		return StreamError{f.streamID, ErrCodeStreamClosed, "DATA after END_STREAM"}
+	}
+	if size > st.recvWindow {
+		c.mu.Unlock()
+		c.returnWindow(nil, size)
+		return StreamError{f.streamID, ErrCodeFlowControl, "stream window exceeded"}
+	}
+	st.recvWindow -= size
+	c.mu.Unlock()
+
+	// Padding is never delivered, so its window comes back right away.
+	if padding := size - int64(len(data)); padding > 0 {
+		c.returnWindow(st, padding)
+	}
+	if err := st.receive(data); err != nil {
+		return err
+	}
+	if f.has(flagEndStream) {
+		return c.endRemote(st)
+	}
+	return nil
+}
+
+func (c *Conn) processHeaders(f frame) error {
+	if f.streamID == 0 {
+		return ConnError{ErrCodeProtocol, "HEADERS on stream 0"}
+	}
+	payload, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	selfDep := false
+	if f.has(flagPriority) {
+		if len(payload) < priorityPayloadLen {
+			return ConnError{ErrCodeFrameSize, "short HEADERS priority"}
+		}
+		selfDep = binary.BigEndian.Uint32(payload)&streamIDMask == f.streamID
+		payload = payload[priorityPayloadLen:]
+	}
+	c.blockStream = f.streamID
+	c.block = append(c.block[:0], payload...)
+	c.blockEndStream = f.has(flagEndStream)
+	c.blockSelfDep = selfDep
+	if f.has(flagEndHeaders) {
+		return c.finishHeaderBlock()
+	}
+	return nil
+}
+
+func (c *Conn) processContinuation(f frame) error {
+	if c.blockStream == 0 {
+		return ConnError{ErrCodeProtocol, "unexpected CONTINUATION"}
+	}
+	if len(c.block)+len(f.payload) > c.opts.Limits.MaxHeaderBytes+defaultMaxFrame {
+		return ConnError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	c.block = append(c.block, f.payload...)
+	if f.has(flagEndHeaders) {
+		return c.finishHeaderBlock()
+	}
+	return nil
+}
+
+func (c *Conn) finishHeaderBlock() error {
+	id := c.blockStream
+	endStream := c.blockEndStream
+	c.blockStream = 0
+	// The block has to be decoded even for streams we refuse, or the
+	// HPACK state would drift from the client's.
+	fields, decodeErr := c.dec.decode(c.block)
+	if decodeErr != nil && !errors.Is(decodeErr, errHeaderListTooLarge) {
+		return ConnError{ErrCodeCompression, decodeErr.Error()}
+	}
+
+	c.mu.Lock()
+	if st := c.streams[id]; st != nil {
+		c.mu.Unlock()
+		if c.blockSelfDep {
+			return StreamError{id, ErrCodeProtocol, "stream depends on itself"}
+		}
+		return c.processTrailers(st, fields, endStream)
+	}
+	if id%2 == 0 || id <= c.lastStreamID {
+		c.mu.Unlock()
+		if id%2 == 0 {
+			return ConnError{ErrCodeProtocol, "client stream IDs must be odd"}
+		}
+		// A stream that has already finished; late frames are dropped.
+		return nil
+	}
+	c.lastStreamID = id
+	if c.blockSelfDep {
+		c.mu.Unlock()
+		return StreamError{id, ErrCodeProtocol, "stream depends on itself"}
+	}
+	if c.goingAway {
+		c.mu.Unlock()
+		return StreamError{id, ErrCodeRefusedStream, "connection is shutting down"}
+	}
+	if uint32(max(len(c.streams), c.running)) >= c.opts.MaxConcurrentStreams {
+		c.mu.Unlock()
+		return StreamError{id, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+	st := newStream(c, id)
+	c.streams[id] = st
+	c.running++
+	if len(c.streams) == 1 {
+		// Handlers may take as long as they like; the idle timeout only
+		// applies while no streams are open.
+		c.nc.SetReadDeadline(time.Time{})
+	}
+	c.mu.Unlock()
+
+	if decodeErr != nil {
+		c.mu.Lock()
+		st.remoteClosed = endStream
+		c.mu.Unlock()
+		c.startStream(st, nil, response.StatusCodeRequestHeaderFieldsTooLarge)
+		return nil
+	}
+	req, err := c.newRequest(st, fields)
+	if err != nil {
+		c.mu.Lock()
+		c.running--
+		c.removeLocked(st)
+		c.mu.Unlock()
+		return StreamError{id, ErrCodeProtocol, err.Error()}
+	}
+	c.startStream(st, req, 0)
+	if endStream {
+		return c.endRemote(st)
+	}
+	return nil
+}
+
+func (c *Conn) processTrailers(st *stream, fields []headerField, endStream bool) error {
+	if st.remoteClosed {
+		return StreamError{st.id, ErrCodeStreamClosed, "HEADERS after END_STREAM"}
+	}
+	if !endStream {
+		return StreamError{st.id, ErrCodeProtocol, "trailers without END_STREAM"}
+	}
+	trailers, err := trailerHeaders(fields)
+	if err != nil {
+		return StreamError{st.id, ErrCodeProtocol, err.Error()}
+	}
+	st.body.setTrailers(trailers)
+	return c.endRemote(st)
+}
+
+func (c *Conn) endRemote(st *stream) error {
+	c.mu.Lock()
+	st.remoteClosed = true
+	if st.localClosed {
+		c.removeLocked(st)
+	}
+	c.mu.Unlock()
+	if err := st.body.finish(); err != nil {
+		return StreamError{st.id, ErrCodeProtocol, err.Error()}
+	}
+	return nil
+}
+
+// startStream runs the handler for st. A non-zero status answers the
+// stream directly without calling the handler.
+func (c *Conn) startStream(st *stream, req *request.Request, status response.StatusCode) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer c.streamDone(st)
+		w := response.NewStreamWriter(st)
+		if status != 0 {
+			response.WriteStatus(w, status)
+			w.Finish()
+			return
+		}
+		if req.RequestLine.Method == "HEAD" {
+			w.SetHEAD()
+		}
+		if !c.serve(w, req) {
+			if w.Committed() {
+				c.resetStream(st.id, ErrCodeInternal)
+				return
+			}
+			w.Reset()
+			response.WriteStatus(w, response.StatusCodeInternalError)
+		}
+		req.Body.Close()
+		w.Finish()
+	}()
+}
+
+func (c *Conn) serve(w *response.Writer, req *request.Request) (ok bool) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, rec, debug.Stack())
+			ok = false
+		}
+	}()
+	c.handler(w, req)
+	return true
+}
+
+// streamDone runs once the handler has returned. A client still sending
+// the request body is told to stop.
+func (c *Conn) streamDone(st *stream) {
+	c.mu.Lock()
+	st.localClosed = true
+	stillSending := !st.remoteClosed && !st.reset
+	c.mu.Unlock()
+	st.body.Close()
+	if stillSending {
+		c.resetStream(st.id, ErrCodeNo)
+	}
+	c.mu.Lock()
+	c.running--
+	c.removeLocked(st)
+	c.mu.Unlock()
+}
+
+// removeLocked forgets a stream once both sides are done with it, and
+// finishes a pending Shutdown when it was the last one.
+func (c *Conn) removeLocked(st *stream) {
+	if !st.localClosed && !st.reset {
+		return
+	}
+	if _, ok := c.streams[st.id]; !ok {
+		return
+	}
+	delete(c.streams, st.id)
+	if len(c.streams) == 0 {
+		if c.goingAway {
+			c.nc.Close()
+		} else {
+			c.nc.SetReadDeadline(netutil.Deadline(time.Now(), c.opts.IdleTimeout))
+		}
+	}
+}
+
+func (c *Conn) resetStream(id uint32, code ErrCode) {
+	c.mu.Lock()
+	if st := c.streams[id]; st != nil {
+		st.reset = true
+		st.body.fail(errStreamClosed)
+		c.removeLocked(st)
+		c.cond.Broadcast()
+	}
+	c.mu.Unlock()
+	payload := binary.BigEndian.AppendUint32(nil, uint32(code))
+	c.writeControl(frameRSTStream, 0, id, payload)
+}
+
+// returnWindow gives n received bytes back to the client, batching the
+// WINDOW_UPDATE frames. A nil stream only credits the connection.
+func (c *Conn) returnWindow(st *stream, n int64) {
+	c.mu.Lock()
+	c.recvWindow += n
+	c.pendingUpdate += n
+	connInc := int64(0)
+	if c.pendingUpdate >= windowUpdateTrigger {
+		connInc, c.pendingUpdate = c.pendingUpdate, 0
+	}
+	streamInc := int64(0)
+	if st != nil && !st.remoteClosed && !st.reset {
+		st.recvWindow += n
+		st.pendingUpdate += n
+		if st.pendingUpdate >= windowUpdateTrigger {
+			streamInc, st.pendingUpdate = st.pendingUpdate, 0
+		}
+	}
+	c.mu.Unlock()
+	if connInc > 0 {
+		c.writeWindowUpdate(0, connInc)
+	}
+	if streamInc > 0 {
+		c.writeWindowUpdate(st.id, streamInc)
+	}
+}
+
+func (c *Conn) writeWindowUpdate(id uint32, inc int64) error {
+	return c.writeControl(frameWindowUpdate, 0, id, binary.BigEndian.AppendUint32(nil, uint32(inc)))
+}
+
+func (c *Conn) writeGoAway(lastStreamID uint32, code ErrCode) error {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	return c.writeControl(frameGoAway, 0, 0, payload)
+}
+
+// writeControl writes a single frame and flushes it right away.
+func (c *Conn) writeControl(typ frameType, flags uint8, id uint32, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.writeFrameLocked(typ, flags, id, payload); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+func (c *Conn) writeFrameLocked(typ frameType, flags uint8, id uint32, payload []byte) error {
+	if c.opts.WriteTimeout > 0 {
+		c.nc.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+	}
+	return writeFrame(c.bw, typ, flags, id, payload)
+}
+
+func (c *Conn) flush() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.bw.Flush()
+}
+
+var connectionHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade"}
+
+func isConnectionHeader(name string) bool {
+	for _, h := range connectionHeaders {
+		if len(h) == len(name) && bytes.EqualFold([]byte(h), []byte(name)) {
+			return true
+		}
+	}
+	return false
+}
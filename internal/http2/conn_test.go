@@ -0,0 +1,421 @@
+package http2_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jud1k/web_server/internal/headers"
+	"github.com/Jud1k/web_server/internal/http2"
+	"github.com/Jud1k/web_server/internal/request"
+	"github.com/Jud1k/web_server/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testServer struct {
+	mu    sync.Mutex
+	conns []*http2.Conn
+}
+
+func (s *testServer) shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Shutdown()
+	}
+}
+
+// startServer serves handler on a loopback listener and returns a client
+// that speaks h2c with prior knowledge to it.
+func startServer(t *testing.T, handler http2.Handler) (*http.Client, *testServer) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &testServer{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			nc, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn := http2.NewConn(nc, nc, handler, http2.Options{})
+			srv.mu.Lock()
+			srv.conns = append(srv.conns, conn)
+			srv.mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn.Serve()
+			}()
+		}
+	}()
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{
+		Protocols: protocols,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("tcp", listener.Addr().String())
+		},
+	}
+	t.Cleanup(func() {
+		transport.CloseIdleConnections()
+		listener.Close()
+		wg.Wait()
+	})
+	return &http.Client{Transport: transport}, srv
+}
+
+func text(body string) http2.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		h := headers.Headers{}
+		h.Set("Content-Type", "text/plain")
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestGet(t *testing.T) {
+	var got *request.Request
+	client, _ := startServer(t, func(w *response.Writer, req *request.Request) {
+		got = req
+		text("hello")(w, req)
+	})
+	resp, err := client.Get("http://example.com/greet?name=gopher")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+
+	assert.Equal(t, "GET", got.RequestLine.Method)
+	assert.Equal(t, "2", got.RequestLine.HttpVersion)
+	assert.Equal(t, "/greet", got.Path())
+	assert.Equal(t, "gopher", got.Query().Get("name"))
+	assert.Equal(t, "example.com", got.Headers.Get("Host"))
+}
+
+func TestRequestHeadersAndCookies(t *testing.T) {
+	var got *request.Request
+	client, _ := startServer(t, func(w *response.Writer, req *request.Request) {
+		got = req
+		text("ok")(w, req)
+	})
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Custom", "value")
+	req.AddCookie(&http.Cookie{Name: "a", Value: "1"})
+	req.AddCookie(&http.Cookie{Name: "b", Value: "2"})
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "value", got.Headers.Get("X-Custom"))
+	c, err := got.Cookie("b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", c.Value)
+}
+
+func TestLargeBodiesBothWays(t *testing.T) {
+	client, _ := startServer(t, func(w *response.Writer, req *request.Request) {
+		sum := sha256.New()
+		n, err := io.Copy(sum, req.Body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+		}
+		w.WriteStatusLine(response.StatusCodeOk)
+		h := headers.Headers{}
+		h.Set("X-Body-Sha256", fmt.Sprintf("%x", sum.Sum(nil)))
+		h.Set("X-Body-Length", fmt.Sprint(n))
+		w.WriteHeaders(h)
+		chunk := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+		for range 200 {
+			if _, err := w.WriteBody(chunk); err != nil {
+				return
+			}
+		}
+	})
+	upload := bytes.Repeat([]byte("upload!"), 500_000)
+	resp, err := client.Post("http://example.com/echo", "application/octet-stream", bytes.NewReader(upload))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(upload)), resp.Header.Get("X-Body-Sha256"))
+	assert.Equal(t, fmt.Sprint(len(upload)), resp.Header.Get("X-Body-Length"))
+	assert.Equal(t, 200*16*1024, len(body))
+}
+
+func TestConcurrentStreams(t *testing.T) {
+	client, _ := startServer(t, func(w *response.Writer, req *request.Request) {
+		time.Sleep(20 * time.Millisecond)
+		text(req.Path())(w, req)
+	})
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path := fmt.Sprintf("/stream/%d", i)
+			resp, err := client.Get("http://example.com" + path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, path, string(body))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTrailers(t *testing.T) {
+	client, _ := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := headers.Headers{}
+		h.Set("Trailer", "X-Checksum")
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("data"))
+		trailers := headers.Headers{}
+		trailers.Set("X-Checksum", "abc")
+		w.WriteTrailers(trailers)
+	})
+	resp, err := client.Get("http://example.com/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestConnectionHeadersAreDropped(t *testing.T) {
+	client, _ := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.SetConnectionClose()
+		text("bye")(w, req)
+	})
+	resp, err := client.Get("http://example.com/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Empty(t, resp.Header.Get("Connection"))
+	assert.Empty(t, resp.Header.Get("Transfer-Encoding"))
+}
+
+func TestPanicAnswers500(t *testing.T) {
+	client, _ := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.Path() == "/panic" {
+			panic("boom")
+		}
+		text("fine")(w, req)
+	})
+	resp, err := client.Get("http://example.com/panic")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	resp, err = client.Get("http://example.com/after")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "fine", string(body))
+}
+
+func TestShutdownFinishesInFlightStreams(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	client, srv := startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		text("finished")(w, req)
+	})
+	result := make(chan string, 1)
+	go func() {
+		resp, err := client.Get("http://example.com/slow")
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		result <- string(body)
+	}()
+	<-started
+	srv.shutdown()
+	close(release)
+	assert.Equal(t, "finished", <-result)
+}
+
+func TestRejectsBadPreface(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	conn := http2.NewConn(serverConn, serverConn, text("unused"), http2.Options{})
+	errc := make(chan error, 1)
+	go func() { errc <- conn.Serve() }()
+	go io.Copy(io.Discard, clientConn)
+	clientConn.Write([]byte(strings.Repeat("x", len(http2.ClientPreface))))
+	assert.Error(t, <-errc)
+}
+
+func writeRawFrame(t *testing.T, w io.Writer, typ, flags byte, id uint32, payload []byte) {
+	t.Helper()
+	hdr := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), typ, flags}
+	hdr = binary.BigEndian.AppendUint32(hdr, id)
+	_, err := w.Write(append(hdr, payload...))
+	require.NoError(t, err)
+}
+
+func TestResetStreamsStillCountTowardsLimit(t *testing.T) {
+	const limit = 4
+	var running, peak atomic.Int32
+	release := make(chan struct{})
+	serverConn, clientConn := net.Pipe()
+	conn := http2.NewConn(serverConn, serverConn, func(w *response.Writer, req *request.Request) {
+		n := running.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+	}, http2.Options{MaxConcurrentStreams: limit})
+	done := make(chan struct{})
+	go func() {
+		conn.Serve()
+		close(done)
+	}()
+	defer func() {
+		clientConn.Close()
+		<-done
+	}()
+	defer close(release)
+
+	// Wait for the PING acknowledgement so we know every frame sent
+	// before it was processed.
+	pong := make(chan struct{})
+	go func() {
+		var hdr [9]byte
+		for {
+			if _, err := io.ReadFull(clientConn, hdr[:]); err != nil {
+				return
+			}
+			payload := make([]byte, int(hdr[0])<<16|int(hdr[1])<<8|int(hdr[2]))
+			if _, err := io.ReadFull(clientConn, payload); err != nil {
+				return
+			}
+			if hdr[3] == 0x6 && hdr[4]&0x1 != 0 {
+				close(pong)
+			}
+		}
+	}()
+
+	w := bufio.NewWriter(clientConn)
+	io.WriteString(w, http2.ClientPreface)
+	writeRawFrame(t, w, 0x4, 0, 0, nil)
+	// :method GET, :scheme http, :path / from the static table.
+	block := []byte{0x82, 0x86, 0x84}
+	cancel := binary.BigEndian.AppendUint32(nil, 0x8)
+	for i := range 200 {
+		id := uint32(2*i + 1)
+		writeRawFrame(t, w, 0x1, 0x5, id, block)
+		writeRawFrame(t, w, 0x3, 0, id, cancel)
+	}
+	writeRawFrame(t, w, 0x6, 0, 0, make([]byte, 8))
+	require.NoError(t, w.Flush())
+
+	select {
+	case <-pong:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no PING acknowledgement")
+	}
+	require.Eventually(t, func() bool { return running.Load() == limit }, 5*time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(limit), peak.Load())
+}
+
+func TestSelfDependentStreamKeepsHPACKInSync(t *testing.T) {
+	got := make(chan string, 1)
+	serverConn, clientConn := net.Pipe()
+	conn := http2.NewConn(serverConn, serverConn, func(w *response.Writer, req *request.Request) {
+		got <- req.Headers.Get("X-A")
+	}, http2.Options{})
+	done := make(chan struct{})
+	go func() {
+		conn.Serve()
+		close(done)
+	}()
+	defer func() {
+		clientConn.Close()
+		<-done
+	}()
+
+	type frameInfo struct {
+		typ byte
+		id  uint32
+	}
+	frames := make(chan frameInfo, 64)
+	go func() {
+		var hdr [9]byte
+		for {
+			if _, err := io.ReadFull(clientConn, hdr[:]); err != nil {
+				return
+			}
+			payload := make([]byte, int(hdr[0])<<16|int(hdr[1])<<8|int(hdr[2]))
+			if _, err := io.ReadFull(clientConn, payload); err != nil {
+				return
+			}
+			frames <- frameInfo{hdr[3], binary.BigEndian.Uint32(hdr[5:]) & 0x7fffffff}
+		}
+	}()
+
+	w := bufio.NewWriter(clientConn)
+	io.WriteString(w, http2.ClientPreface)
+	writeRawFrame(t, w, 0x4, 0, 0, nil)
+	// Stream 1 depends on itself and adds "x-a: 1" to the dynamic table.
+	priority := binary.BigEndian.AppendUint32(nil, 1)
+	priority = append(priority, 15)
+	block := []byte{0x82, 0x86, 0x84, 0x40, 0x03, 'x', '-', 'a', 0x01, '1'}
+	writeRawFrame(t, w, 0x1, 0x25, 1, append(priority, block...))
+	// Stream 3 refers to that entry as index 62.
+	writeRawFrame(t, w, 0x1, 0x5, 3, []byte{0x82, 0x86, 0x84, 0xbe})
+	require.NoError(t, w.Flush())
+
+	select {
+	case v := <-got:
+		assert.Equal(t, "1", v)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream 3 was not served")
+	}
+	reset := false
+	for !reset {
+		select {
+		case f := <-frames:
+			require.NotEqual(t, byte(0x7), f.typ, "unexpected GOAWAY")
+			reset = f.typ == 0x3 && f.id == 1
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream 1 was not reset")
+		}
+	}
+}
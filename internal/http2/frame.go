@@ -0,0 +1,235 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	frameHeaderLen     = 9
+	defaultMaxFrame    = 16384
+	maxFrameSizeLimit  = 1<<24 - 1
+	defaultWindowSize  = 65535
+	maxWindowSize      = 1<<31 - 1
+	defaultTableSize   = 4096
+	streamIDMask       = 1<<31 - 1
+	settingsEntryLen   = 6
+	pingPayloadLen     = 8
+	goAwayMinLen       = 8
+	windowUpdateLen    = 4
+	rstStreamLen       = 4
+	priorityPayloadLen = 5
+)
+
+type frameType uint8
+
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+type settingID uint16
+
+const (
+	settingHeaderTableSize      settingID = 0x1
+	settingEnablePush           settingID = 0x2
+	settingMaxConcurrentStreams settingID = 0x3
+	settingInitialWindowSize    settingID = 0x4
+	settingMaxFrameSize         settingID = 0x5
+	settingMaxHeaderListSize    settingID = 0x6
+)
+
+type setting struct {
+	id    settingID
+	value uint32
+}
+
+// ErrCode is an HTTP/2 error code carried by RST_STREAM and GOAWAY.
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+var errCodeNames = map[ErrCode]string{
+	ErrCodeNo:                 "NO_ERROR",
+	ErrCodeProtocol:           "PROTOCOL_ERROR",
+	ErrCodeInternal:           "INTERNAL_ERROR",
+	ErrCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	ErrCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	ErrCodeStreamClosed:       "STREAM_CLOSED",
+	ErrCodeFrameSize:          "FRAME_SIZE_ERROR",
+	ErrCodeRefusedStream:      "REFUSED_STREAM",
+	ErrCodeCancel:             "CANCEL",
+	ErrCodeCompression:        "COMPRESSION_ERROR",
+	ErrCodeConnect:            "CONNECT_ERROR",
+	ErrCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	ErrCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	ErrCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (c ErrCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint32(c))
+}
+
+// ConnError ends the whole connection with a GOAWAY.
+type ConnError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e ConnError) Error() string {
+	return fmt.Sprintf("error: http2 connection error %s: %s", e.Code, e.Reason)
+}
+
+// StreamError resets a single stream with RST_STREAM.
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	Reason   string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("error: http2 stream %d error %s: %s", e.StreamID, e.Code, e.Reason)
+}
+
+type frameHeader struct {
+	length   uint32
+	typ      frameType
+	flags    uint8
+	streamID uint32
+}
+
+func (h frameHeader) has(flag uint8) bool {
+	return h.flags&flag != 0
+}
+
+type frame struct {
+	frameHeader
+	payload []byte
+}
+
+// readFrame reads one frame into buf, growing it as needed. The payload
+// aliases buf and is only valid until the next call.
+func readFrame(r io.Reader, buf []byte, maxSize uint32) (frame, []byte, error) {
+	var hdr [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return frame{}, buf, err
+	}
+	f := frame{frameHeader: frameHeader{
+		length:   uint32(hdr[0])<<16 | uint32(hdr[1])<<8 | uint32(hdr[2]),
+		typ:      frameType(hdr[3]),
+		flags:    hdr[4],
+		streamID: binary.BigEndian.Uint32(hdr[5:]) & streamIDMask,
+	}}
+	if f.length > maxSize {
+		return frame{}, buf, ConnError{ErrCodeFrameSize, fmt.Sprintf("frame of %d bytes exceeds %d", f.length, maxSize)}
+	}
+	if cap(buf) < int(f.length) {
+		buf = make([]byte, f.length)
+	}
+	f.payload = buf[:f.length]
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, buf, err
+	}
+	return f, buf, nil
+}
+
+func appendFrameHeader(dst []byte, typ frameType, flags uint8, streamID uint32, length int) []byte {
+	return append(dst,
+		byte(length>>16), byte(length>>8), byte(length),
+		byte(typ), flags,
+		byte(streamID>>24)&0x7f, byte(streamID>>16), byte(streamID>>8), byte(streamID))
+}
+
+func writeFrame(w io.Writer, typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	buf := appendFrameHeader(make([]byte, 0, frameHeaderLen+len(payload)), typ, flags, streamID, len(payload))
+	_, err := w.Write(append(buf, payload...))
+	return err
+}
+
+// stripPadding removes the pad length byte and trailing padding of a
+// PADDED DATA or HEADERS payload.
+func stripPadding(f frame) ([]byte, error) {
+	if !f.has(flagPadded) {
+		return f.payload, nil
+	}
+	if len(f.payload) == 0 {
+		return nil, ConnError{ErrCodeProtocol, "padded frame without pad length"}
+	}
+	padLen := int(f.payload[0])
+	if padLen >= len(f.payload) {
+		return nil, ConnError{ErrCodeProtocol, "padding exceeds frame payload"}
+	}
+	return f.payload[1 : len(f.payload)-padLen], nil
+}
+
+func parseSettings(payload []byte) ([]setting, error) {
+	if len(payload)%settingsEntryLen != 0 {
+		return nil, ConnError{ErrCodeFrameSize, "SETTINGS payload not a multiple of 6"}
+	}
+	settings := make([]setting, 0, len(payload)/settingsEntryLen)
+	for i := 0; i < len(payload); i += settingsEntryLen {
+		s := setting{
+			id:    settingID(binary.BigEndian.Uint16(payload[i:])),
+			value: binary.BigEndian.Uint32(payload[i+2:]),
+		}
+		switch s.id {
+		case settingEnablePush:
+			if s.value > 1 {
+				return nil, ConnError{ErrCodeProtocol, "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case settingInitialWindowSize:
+			if s.value > maxWindowSize {
+				return nil, ConnError{ErrCodeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE too large"}
+			}
+		case settingMaxFrameSize:
+			if s.value < defaultMaxFrame || s.value > maxFrameSizeLimit {
+				return nil, ConnError{ErrCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+func appendSettings(dst []byte, settings ...setting) []byte {
+	for _, s := range settings {
+		dst = binary.BigEndian.AppendUint16(dst, uint16(s.id))
+		dst = binary.BigEndian.AppendUint32(dst, s.value)
+	}
+	return dst
+}
@@ -0,0 +1,331 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	errHpackTruncated     = errors.New("error: truncated header block")
+	errHpackIntOverflow   = errors.New("error: hpack integer overflow")
+	errHpackBadIndex      = errors.New("error: invalid hpack table index")
+	errHpackTableSize     = errors.New("error: dynamic table size update over the limit")
+	errHeaderListTooLarge = errors.New("error: header list too large")
+)
+
+type headerField struct {
+	name  string
+	value string
+}
+
+func (f headerField) size() int {
+	return len(f.name) + len(f.value) + 32
+}
+
+// staticTable is RFC 7541 Appendix A; index 1 is staticTable[0].
+var staticTable = []headerField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// dynamicTable keeps the newest entry at the end of entries.
+type dynamicTable struct {
+	entries []headerField
+	size    int
+	maxSize int
+}
+
+func (t *dynamicTable) add(f headerField) {
+	t.entries = append(t.entries, f)
+	t.size += f.size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n int) {
+	t.maxSize = n
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	drop := 0
+	for t.size > t.maxSize && drop < len(t.entries) {
+		t.size -= t.entries[drop].size()
+		drop++
+	}
+	t.entries = t.entries[drop:]
+}
+
+// decoder decodes header blocks for one connection. maxTableSize is the
+// SETTINGS_HEADER_TABLE_SIZE we advertised.
+type decoder struct {
+	table         dynamicTable
+	maxTableSize  int
+	maxHeaderList int
+}
+
+func newDecoder(maxTableSize, maxHeaderList int) *decoder {
+	return &decoder{
+		table:         dynamicTable{maxSize: maxTableSize},
+		maxTableSize:  maxTableSize,
+		maxHeaderList: maxHeaderList,
+	}
+}
+
+func (d *decoder) field(index uint64) (headerField, error) {
+	if index == 0 {
+		return headerField{}, errHpackBadIndex
+	}
+	if index <= uint64(len(staticTable)) {
+		return staticTable[index-1], nil
+	}
+	i := index - uint64(len(staticTable))
+	if i > uint64(len(d.table.entries)) {
+		return headerField{}, errHpackBadIndex
+	}
+	return d.table.entries[len(d.table.entries)-int(i)], nil
+}
+
+// decode decodes a complete header block. A block over the header list
+// limit is still decoded to the end so the dynamic table stays in sync.
+func (d *decoder) decode(block []byte) ([]headerField, error) {
+	var fields []headerField
+	listSize := 0
+	tooLarge := false
+	for len(block) > 0 {
+		b := block[0]
+		var f headerField
+		var err error
+		switch {
+		case b&0x80 != 0:
+			var index uint64
+			index, block, err = readInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, err = d.field(index)
+			if err != nil {
+				return nil, err
+			}
+		case b&0xc0 == 0x40:
+			f, block, err = d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+		case b&0xe0 == 0x20:
+			if listSize > 0 {
+				return nil, fmt.Errorf("%w: must start the block", errHpackTableSize)
+			}
+			var size uint64
+			size, block, err = readInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.maxTableSize) {
+				return nil, errHpackTableSize
+			}
+			d.table.setMaxSize(int(size))
+			continue
+		default:
+			f, block, err = d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+		}
+		listSize += f.size()
+		if listSize > d.maxHeaderList {
+			tooLarge = true
+			fields = nil
+		}
+		if !tooLarge {
+			fields = append(fields, f)
+		}
+	}
+	if tooLarge {
+		return nil, errHeaderListTooLarge
+	}
+	return fields, nil
+}
+
+func (d *decoder) readLiteral(block []byte, prefix uint8) (headerField, []byte, error) {
+	index, block, err := readInt(block, prefix)
+	if err != nil {
+		return headerField{}, nil, err
+	}
+	var f headerField
+	if index > 0 {
+		indexed, err := d.field(index)
+		if err != nil {
+			return headerField{}, nil, err
+		}
+		f.name = indexed.name
+	} else {
+		f.name, block, err = readString(block)
+		if err != nil {
+			return headerField{}, nil, err
+		}
+	}
+	f.value, block, err = readString(block)
+	if err != nil {
+		return headerField{}, nil, err
+	}
+	return f, block, nil
+}
+
+func readInt(block []byte, prefix uint8) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, errHpackTruncated
+	}
+	mask := uint64(1)<<prefix - 1
+	v := uint64(block[0]) & mask
+	block = block[1:]
+	if v < mask {
+		return v, block, nil
+	}
+	var shift uint
+	for len(block) > 0 {
+		b := block[0]
+		block = block[1:]
+		if shift > 56 {
+			return 0, nil, errHpackIntOverflow
+		}
+		v += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, block, nil
+		}
+		shift += 7
+	}
+	return 0, nil, errHpackTruncated
+}
+
+func readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, errHpackTruncated
+	}
+	huffman := block[0]&0x80 != 0
+	n, block, err := readInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if n > uint64(len(block)) {
+		return "", nil, errHpackTruncated
+	}
+	raw := block[:n]
+	block = block[n:]
+	if !huffman {
+		return string(raw), block, nil
+	}
+	decoded, err := huffmanDecode(nil, raw)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(decoded), block, nil
+}
+
+// appendField encodes a field without adding it to the peer's dynamic
+// table, so encoding needs no state and the peer's
+// SETTINGS_HEADER_TABLE_SIZE doesn't matter.
+func appendField(dst []byte, name, value string) []byte {
+	name = strings.ToLower(name)
+	nameIndex := 0
+	for i, f := range staticTable {
+		if f.name != name {
+			continue
+		}
+		if f.value == value {
+			return appendInt(dst, 0x80, 7, uint64(i+1))
+		}
+		if nameIndex == 0 {
+			nameIndex = i + 1
+		}
+	}
+	// Literal header field without indexing.
+	dst = appendInt(dst, 0, 4, uint64(nameIndex))
+	if nameIndex == 0 {
+		dst = appendString(dst, name)
+	}
+	return appendString(dst, value)
+}
+
+func appendInt(dst []byte, flags byte, prefix uint8, v uint64) []byte {
+	mask := uint64(1)<<prefix - 1
+	if v < mask {
+		return append(dst, flags|byte(v))
+	}
+	dst = append(dst, flags|byte(mask))
+	v -= mask
+	for v >= 0x80 {
+		dst = append(dst, byte(v&0x7f)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return huffmanEncode(dst, s)
+	}
+	dst = appendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
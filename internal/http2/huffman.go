@@ -0,0 +1,99 @@
+package http2
+
+import "errors"
+
+var errInvalidHuffman = errors.New("error: invalid huffman-encoded string")
+
+type huffmanCode struct {
+	code uint32
+	bits uint8
+}
+
+// huffmanNode is a node of the decoding tree. Leaves have no children and
+// hold the decoded symbol, 256 being EOS.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      int
+}
+
+var huffmanRoot = buildHuffmanTree()
+
+func buildHuffmanTree() *huffmanNode {
+	root := &huffmanNode{}
+	insert := func(sym int, c huffmanCode) {
+		n := root
+		for i := int(c.bits) - 1; i >= 0; i-- {
+			bit := (c.code >> uint(i)) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &huffmanNode{}
+			}
+			n = n.children[bit]
+		}
+		n.sym = sym
+	}
+	for sym, c := range huffmanCodes {
+		insert(sym, c)
+	}
+	insert(256, huffmanEOS)
+	return root
+}
+
+func huffmanDecode(dst, src []byte) ([]byte, error) {
+	n := huffmanRoot
+	// depth counts the bits read since the last symbol; they must be a
+	// prefix of EOS (all ones) and shorter than a byte at the end.
+	depth := 0
+	ones := true
+	for _, b := range src {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			n = n.children[bit]
+			if n == nil {
+				return nil, errInvalidHuffman
+			}
+			depth++
+			ones = ones && bit == 1
+			if n.children[0] != nil || n.children[1] != nil {
+				continue
+			}
+			if n.sym == 256 {
+				return nil, errInvalidHuffman
+			}
+			dst = append(dst, byte(n.sym))
+			n = huffmanRoot
+			depth = 0
+			ones = true
+		}
+	}
+	if depth > 7 || !ones {
+		return nil, errInvalidHuffman
+	}
+	return dst, nil
+}
+
+func huffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodes[s[i]].bits)
+	}
+	return (bits + 7) / 8
+}
+
+func huffmanEncode(dst []byte, s string) []byte {
+	var acc uint64
+	var n uint
+	for i := 0; i < len(s); i++ {
+		c := huffmanCodes[s[i]]
+		acc = acc<<c.bits | uint64(c.code)
+		n += uint(c.bits)
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		// Pad with the most significant bits of EOS, which are all ones.
+		dst = append(dst, byte(acc<<(8-n))|byte(0xff>>n))
+	}
+	return dst
+}
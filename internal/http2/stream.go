@@ -0,0 +1,374 @@
+package http2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Jud1k/web_server/internal/headers"
+	"github.com/Jud1k/web_server/internal/request"
+	"github.com/Jud1k/web_server/internal/response"
+)
+
+// stream is one request/response exchange. The flow-control and state
+// fields are guarded by conn.mu.
+type stream struct {
+	id            uint32
+	conn          *Conn
+	body          *requestBody
+	sendWindow    int64
+	recvWindow    int64
+	pendingUpdate int64
+	remoteClosed  bool
+	localClosed   bool
+	reset         bool
+}
+
+func newStream(c *Conn, id uint32) *stream {
+	st := &stream{
+		id:         id,
+		conn:       c,
+		sendWindow: c.peerWindow,
+		recvWindow: initialRecvWindow,
+	}
+	st.body = newRequestBody(st, c.opts.Limits.MaxBodyBytes)
+	return st
+}
+
+func (st *stream) receive(data []byte) error {
+	return st.body.write(data)
+}
+
+func (st *stream) WriteHeaders(statusCode response.StatusCode, h headers.Headers) error {
+	block := appendField(nil, ":status", strconv.Itoa(int(statusCode)))
+	block = appendHeaders(block, h)
+	return st.writeHeaderBlock(block, false)
+}
+
+func (st *stream) Write(p []byte) (int, error) {
+	c := st.conn
+	written := 0
+	for len(p) > 0 {
+		c.mu.Lock()
+		if (c.sendWindow <= 0 || st.sendWindow <= 0) && !st.reset && !c.closed {
+			// Make sure the client has everything sent so far before
+			// waiting for it to open the window again.
+			c.mu.Unlock()
+			if err := c.flush(); err != nil {
+				return written, err
+			}
+			c.mu.Lock()
+			for (c.sendWindow <= 0 || st.sendWindow <= 0) && !st.reset && !c.closed {
+				c.cond.Wait()
+			}
+		}
+		if st.reset || c.closed {
+			c.mu.Unlock()
+			return written, errStreamClosed
+		}
+		n := min(int64(len(p)), c.sendWindow, st.sendWindow, int64(c.peerMaxFrame))
+		c.sendWindow -= n
+		st.sendWindow -= n
+		c.mu.Unlock()
+
+		c.wmu.Lock()
+		err := c.writeFrameLocked(frameData, 0, st.id, p[:n])
+		c.wmu.Unlock()
+		if err != nil {
+			return written, err
+		}
+		written += int(n)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (st *stream) Close(trailers headers.Headers) error {
+	if len(trailers) > 0 {
+		return st.writeHeaderBlock(appendHeaders(nil, trailers), true)
+	}
+	c := st.conn
+	if st.isReset() {
+		return errStreamClosed
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeFrameLocked(frameData, flagEndStream, st.id, nil)
+}
+
+func (st *stream) Flush() error {
+	return st.conn.flush()
+}
+
+func (st *stream) isReset() bool {
+	st.conn.mu.Lock()
+	defer st.conn.mu.Unlock()
+	return st.reset || st.conn.closed
+}
+
+// writeHeaderBlock sends a HEADERS frame followed by as many CONTINUATION
+// frames as the peer's maximum frame size requires.
+func (st *stream) writeHeaderBlock(block []byte, endStream bool) error {
+	c := st.conn
+	if st.isReset() {
+		return errStreamClosed
+	}
+	c.mu.Lock()
+	maxFrame := int(c.peerMaxFrame)
+	c.mu.Unlock()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	typ := frameHeaders
+	var flags uint8
+	if endStream {
+		flags = flagEndStream
+	}
+	for {
+		chunk := block[:min(len(block), maxFrame)]
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+		if err := c.writeFrameLocked(typ, flags, st.id, chunk); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		typ = frameContinuation
+		flags = 0
+	}
+}
+
+// appendHeaders encodes h in sorted order, leaving out the fields that
+// only make sense for HTTP/1 connections.
+func appendHeaders(block []byte, h headers.Headers) []byte {
+	for _, key := range slices.Sorted(maps.Keys(h)) {
+		if isConnectionHeader(key) {
+			continue
+		}
+		for _, value := range h[key] {
+			block = appendField(block, key, value)
+		}
+	}
+	return block
+}
+
+// newRequest turns a decoded header block into a request, checking the
+// rules of RFC 9113 section 8.3.
+func (c *Conn) newRequest(st *stream, fields []headerField) (*request.Request, error) {
+	var method, scheme, path, authority string
+	h := headers.Headers{}
+	regular := false
+	for _, f := range fields {
+		if f.name != strings.ToLower(f.name) {
+			return nil, fmt.Errorf("uppercase field name %q", f.name)
+		}
+		if strings.HasPrefix(f.name, ":") {
+			if regular {
+				return nil, errors.New("pseudo-header after regular field")
+			}
+			var dst *string
+			switch f.name {
+			case ":method":
+				dst = &method
+			case ":scheme":
+				dst = &scheme
+			case ":path":
+				dst = &path
+			case ":authority":
+				dst = &authority
+			default:
+				return nil, fmt.Errorf("unknown pseudo-header %q", f.name)
+			}
+			if *dst != "" {
+				return nil, fmt.Errorf("duplicate pseudo-header %q", f.name)
+			}
+			*dst = f.value
+			continue
+		}
+		regular = true
+		if err := checkField(f); err != nil {
+			return nil, err
+		}
+		h.Add(f.name, f.value)
+	}
+	target := path
+	if method == "CONNECT" {
+		if authority == "" || scheme != "" || path != "" {
+			return nil, errors.New("CONNECT needs only :method and :authority")
+		}
+		target = authority
+	} else if method == "" || scheme == "" || path == "" {
+		return nil, errors.New("missing required pseudo-header")
+	}
+	if authority != "" && !h.Has("Host") {
+		h.Set("Host", authority)
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		n, ok := headers.ParseContentLength(cl)
+		if !ok {
+			return nil, request.ErrBadContentLength
+		}
+		st.body.declared = n
+	}
+	req, err := request.New(method, target, "2", h, st.body)
+	if err != nil {
+		return nil, err
+	}
+	req.TLS = c.opts.TLS
+	st.body.req = req
+	return req, nil
+}
+
+func trailerHeaders(fields []headerField) (headers.Headers, error) {
+	h := headers.Headers{}
+	for _, f := range fields {
+		if strings.HasPrefix(f.name, ":") {
+			return nil, errors.New("pseudo-header in trailers")
+		}
+		if err := checkField(f); err != nil {
+			return nil, err
+		}
+		h.Add(f.name, f.value)
+	}
+	return h, nil
+}
+
+func checkField(f headerField) error {
+	if f.name == "" || f.name != strings.ToLower(f.name) {
+		return fmt.Errorf("invalid field name %q", f.name)
+	}
+	if isConnectionHeader(f.name) {
+		return fmt.Errorf("connection-specific field %q", f.name)
+	}
+	if f.name == "te" && f.value != "trailers" {
+		return errors.New("te may only be \"trailers\"")
+	}
+	if strings.ContainsAny(f.value, "\r\n\x00") {
+		return fmt.Errorf("invalid value for %q", f.name)
+	}
+	return nil
+}
+
+// requestBody is fed by the frame reader and drained by the handler.
+type requestBody struct {
+	st       *stream
+	req      *request.Request
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer
+	err      error
+	closed   bool
+	max      int64
+	declared int64
+	received int64
+}
+
+func newRequestBody(st *stream, max int64) *requestBody {
+	b := &requestBody{st: st, max: max, declared: -1}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	for b.buf.Len() == 0 && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.mu.Unlock()
+		return 0, request.ErrBodyClosed
+	}
+	if b.buf.Len() == 0 {
+		err := b.err
+		b.mu.Unlock()
+		return 0, err
+	}
+	n, _ := b.buf.Read(p)
+	b.mu.Unlock()
+	b.st.conn.returnWindow(b.st, int64(n))
+	return n, nil
+}
+
+// Close discards whatever is buffered, giving its window back to the
+// connection.
+func (b *requestBody) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	n := b.buf.Len()
+	b.buf.Reset()
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	if n > 0 {
+		b.st.conn.returnWindow(nil, int64(n))
+	}
+	return nil
+}
+
+func (b *requestBody) write(data []byte) error {
+	b.mu.Lock()
+	b.received += int64(len(data))
+	if b.declared >= 0 && b.received > b.declared {
+		b.mu.Unlock()
+		return StreamError{b.st.id, ErrCodeProtocol, "body longer than Content-Length"}
+	}
+	if b.closed || b.err != nil {
+		b.mu.Unlock()
+		b.st.conn.returnWindow(nil, int64(len(data)))
+		return nil
+	}
+	if b.max > 0 && b.received > b.max {
+		b.err = request.ErrBodyTooLarge
+		b.cond.Broadcast()
+		b.mu.Unlock()
+		b.st.conn.returnWindow(nil, int64(len(data)))
+		return nil
+	}
+	b.buf.Write(data)
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *requestBody) setTrailers(h headers.Headers) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.req != nil {
+		maps.Copy(b.req.Trailers, h)
+	}
+}
+
+// finish marks the end of the body once the client sent END_STREAM.
+func (b *requestBody) finish() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = io.EOF
+	}
+	b.cond.Broadcast()
+	if b.declared >= 0 && b.received != b.declared {
+		b.err = io.ErrUnexpectedEOF
+		return errors.New("body shorter than Content-Length")
+	}
+	return nil
+}
+
+func (b *requestBody) fail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+}
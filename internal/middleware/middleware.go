@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/Jud1k/web_server/internal/headers"
+	"github.com/Jud1k/web_server/internal/request"
+	"github.com/Jud1k/web_server/internal/response"
+	"github.com/Jud1k/web_server/internal/server"
+)
+
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+func Logging(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		next(w, req)
+		status := w.StatusCode()
+		if status == 0 {
+			// Finish answers a handler that wrote nothing with a 200.
+			status = response.StatusCodeOk
+		}
+		log.Printf("%s %s %d %s", req.RequestLine.Method, req.RequestLine.RequestTarget, status, time.Since(start))
+	}
+}
+
+func Recover(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, rec, debug.Stack())
+				w.SetConnectionClose()
+				if w.Reset() != nil {
+					return
+				}
+				response.WriteStatus(w, response.StatusCodeInternalError)
+			}
+		}()
+		next(w, req)
+	}
+}
+
+func RequestID(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		id := req.Headers.Get(RequestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+		next(w, req.WithContext(ctx))
+	}
+}
+
+func RequestIDFrom(req *request.Request) string {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func Timing(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		w.OnHeaders(func(h headers.Headers) {
+			elapsed := time.Since(start)
+			h.Set("Server-Timing", fmt.Sprintf("app;dur=%.3f", float64(elapsed.Microseconds())/1000))
+		})
+		next(w, req)
+	}
+}
+
+// ClientCertPolicy decides whether a verified client certificate may use
+// a route. A nil policy accepts any verified certificate.
+type ClientCertPolicy func(cert *x509.Certificate) bool
+
+// RequireClientCert answers 403 unless the request came over mutual TLS
+// with a certificate the policy accepts.
+func RequireClientCert(policy ClientCertPolicy) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			cert := req.PeerCertificate()
+			if cert == nil || (policy != nil && !policy(cert)) {
+				response.WriteStatus(w, response.StatusCodeForbidden)
+				return
+			}
+			next(w, req)
+		}
+	}
+}
+
+func AllowCommonNames(names ...string) ClientCertPolicy {
+	return func(cert *x509.Certificate) bool {
+		return slices.Contains(names, cert.Subject.CommonName)
+	}
+}
+
+func AllowDNSNames(names ...string) ClientCertPolicy {
+	return func(cert *x509.Certificate) bool {
+		for _, name := range cert.DNSNames {
+			if slices.Contains(names, name) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,250 @@
+package middleware_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jud1k/web_server/internal/certs"
+	"github.com/Jud1k/web_server/internal/middleware"
+	"github.com/Jud1k/web_server/internal/request"
+	"github.com/Jud1k/web_server/internal/response"
+	"github.com/Jud1k/web_server/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, handler server.Handler, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	handler(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func ok(w *response.Writer, req *request.Request) {
+	body := []byte("ok")
+	w.WriteStatusLine(response.StatusCodeOk)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name)
+				next(w, req)
+			}
+		}
+	}
+	handler := server.Chain(record("first"), record("second"), record("third"))(ok)
+	run(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, []string{"first", "second", "third"}, calls)
+}
+
+func TestLoggingReportsImplicitOK(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	handler := middleware.Logging(func(w *response.Writer, req *request.Request) {})
+	resp := run(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, logs.String(), "GET / 200 ")
+}
+
+func TestRecoverWritesInternalError(t *testing.T) {
+	handler := middleware.Recover(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	})
+	resp := run(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Contains(t, resp, "Connection: close\r\n")
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	var seen string
+	handler := middleware.RequestID(func(w *response.Writer, req *request.Request) {
+		seen = middleware.RequestIDFrom(req)
+		ok(w, req)
+	})
+	resp := run(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	require.Len(t, seen, 32)
+	assert.Contains(t, resp, "X-Request-Id: "+seen+"\r\n")
+}
+
+func TestRequestIDPropagated(t *testing.T) {
+	var seen string
+	handler := middleware.RequestID(func(w *response.Writer, req *request.Request) {
+		seen = middleware.RequestIDFrom(req)
+		ok(w, req)
+	})
+	resp := run(t, handler, "GET / HTTP/1.1\r\nX-Request-ID: abc123\r\n\r\n")
+	assert.Equal(t, "abc123", seen)
+	assert.Contains(t, resp, "X-Request-Id: abc123\r\n")
+}
+
+func TestRequestIDKeepsTrailersVisible(t *testing.T) {
+	var sum string
+	handler := middleware.RequestID(func(w *response.Writer, req *request.Request) {
+		io.Copy(io.Discard, req.Body)
+		sum = req.Trailers.Get("X-Sum")
+		ok(w, req)
+	})
+	run(t, handler, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n"+
+		"3\r\nabc\r\n0\r\nX-Sum: 1\r\n\r\n")
+	assert.Equal(t, "1", sum)
+}
+
+func TestTimingSetsServerTiming(t *testing.T) {
+	resp := run(t, middleware.Timing(ok), "GET / HTTP/1.1\r\n\r\n")
+	assert.Contains(t, resp, "Server-Timing: app;dur=")
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake runs a mutual TLS handshake over a pipe and returns the
+// connection state as the server saw it.
+func handshake(t *testing.T, ca *testCA, mode certs.ClientAuth, client *tls.Certificate) (*tls.ConnectionState, error) {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	srv := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth, "server.test")},
+		ClientAuth:   mode.TLSType(),
+		ClientCAs:    ca.pool,
+	})
+	clientConfig := &tls.Config{RootCAs: ca.pool, ServerName: "server.test"}
+	if client != nil {
+		clientConfig.Certificates = []tls.Certificate{*client}
+	}
+	go func() {
+		c := tls.Client(clientConn, clientConfig)
+		if c.Handshake() == nil {
+			c.Read(make([]byte, 1))
+		}
+	}()
+	if err := srv.Handshake(); err != nil {
+		return nil, err
+	}
+	state := srv.ConnectionState()
+	return &state, nil
+}
+
+func runTLS(t *testing.T, handler server.Handler, state *tls.ConnectionState) string {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	req.TLS = state
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	handler(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func TestRequireClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	client := ca.issue(t, "billing", x509.ExtKeyUsageClientAuth, "billing.internal")
+	state, err := handshake(t, ca, certs.ClientAuthRequired, &client)
+	require.NoError(t, err)
+
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	req.TLS = state
+	cert := req.PeerCertificate()
+	require.NotNil(t, cert)
+	assert.Equal(t, "billing", cert.Subject.CommonName)
+	assert.Equal(t, []string{"billing.internal"}, cert.DNSNames)
+
+	allowed := []server.Middleware{
+		middleware.RequireClientCert(nil),
+		middleware.RequireClientCert(middleware.AllowCommonNames("billing")),
+		middleware.RequireClientCert(middleware.AllowDNSNames("billing.internal")),
+	}
+	for _, mw := range allowed {
+		assert.True(t, strings.HasPrefix(runTLS(t, mw(ok), state), "HTTP/1.1 200 OK\r\n"))
+	}
+	denied := middleware.RequireClientCert(middleware.AllowCommonNames("payroll"))
+	assert.True(t, strings.HasPrefix(runTLS(t, denied(ok), state), "HTTP/1.1 403 Forbidden\r\n"))
+}
+
+func TestRequireClientCertWithoutCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	state, err := handshake(t, ca, certs.ClientAuthOptional, nil)
+	require.NoError(t, err)
+
+	handler := middleware.RequireClientCert(nil)(ok)
+	assert.True(t, strings.HasPrefix(runTLS(t, handler, state), "HTTP/1.1 403 Forbidden\r\n"))
+	assert.True(t, strings.HasPrefix(runTLS(t, handler, nil), "HTTP/1.1 403 Forbidden\r\n"))
+}
+
+func TestRequiredClientAuthRejectsUntrustedCert(t *testing.T) {
+	ca := newTestCA(t)
+	other := newTestCA(t)
+	client := other.issue(t, "intruder", x509.ExtKeyUsageClientAuth)
+	_, err := handshake(t, ca, certs.ClientAuthRequired, &client)
+	assert.Error(t, err)
+
+	_, err = handshake(t, ca, certs.ClientAuthRequired, nil)
+	assert.Error(t, err)
+}
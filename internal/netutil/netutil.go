@@ -0,0 +1,24 @@
+// Package netutil holds the connection deadline helpers shared by the
+// HTTP/1 server and the HTTP/2 connection.
+package netutil
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Deadline returns start+timeout, or the zero time (no deadline) when
+// timeout is not positive.
+func Deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
+
+// IsTimeout reports whether err comes from an expired deadline.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,28 @@
+package netutil_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jud1k/web_server/internal/netutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadline(t *testing.T) {
+	start := time.Now()
+	assert.Equal(t, start.Add(time.Second), netutil.Deadline(start, time.Second))
+	assert.True(t, netutil.Deadline(start, 0).IsZero())
+	assert.True(t, netutil.Deadline(start, -time.Second).IsZero())
+}
+
+func TestIsTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	server.SetReadDeadline(time.Now())
+	_, err := server.Read(make([]byte, 1))
+	assert.True(t, netutil.IsTimeout(err))
+	assert.False(t, netutil.IsTimeout(errors.New("boom")))
+}
@@ -0,0 +1,163 @@
+package request
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"strings"
+
+	"github.com/Jud1k/web_server/internal/headers"
+)
+
+const maxBodyDrain = 256 << 10
+
+type body struct {
+	rd        *Reader
+	req       *Request
+	remaining int64
+	read      int64
+	chunked   *chunkedDecoder
+	closed    bool
+	err       error
+}
+
+func newBody(rd *Reader, r *Request) (*body, error) {
+	b := &body{rd: rd, req: r}
+	transferEncoding := r.Headers.Get("Transfer-Encoding")
+	contentLen := r.Headers.Get("Content-Length")
+	if transferEncoding != "" {
+		if contentLen != "" {
+			return nil, ErrAmbiguousFraming
+		}
+		// RFC 9112 section 6.1: Transfer-Encoding in an HTTP/1.0 message
+		// means the framing is faulty.
+		if !r.ProtoAtLeast(1, 1) {
+			return nil, wrapError(ErrAmbiguousFraming, "Transfer-Encoding in an HTTP/1.0 request")
+		}
+		if !strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked") {
+			return nil, ErrUnsupportedTransferEncoding
+		}
+		b.chunked = newChunkedDecoder(rd.limits)
+		return b, nil
+	}
+	if contentLen == "" {
+		b.err = io.EOF
+		return b, nil
+	}
+	contentLenInt, ok := headers.ParseContentLength(contentLen)
+	if !ok {
+		return nil, ErrBadContentLength
+	}
+	if rd.limits.MaxBodyBytes > 0 && contentLenInt > rd.limits.MaxBodyBytes {
+		return nil, ErrBodyTooLarge
+	}
+	b.remaining = contentLenInt
+	if contentLenInt == 0 {
+		b.err = io.EOF
+	}
+	return b, nil
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	var n int
+	if b.chunked != nil {
+		n, b.err = b.readChunked(p)
+	} else {
+		n, b.err = b.readFixed(p)
+	}
+	b.read += int64(n)
+	if limit := b.rd.limits.MaxBodyBytes; limit > 0 && b.read > limit {
+		b.err = ErrBodyTooLarge
+		return 0, b.err
+	}
+	if n > 0 && b.err == io.EOF {
+		return n, nil
+	}
+	return n, b.err
+}
+
+func (b *body) readFixed(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	var n int
+	var err error
+	if buffered := b.rd.buffered(); len(buffered) > 0 {
+		n = copy(p, buffered)
+		b.rd.discard(n)
+	} else {
+		n, err = b.rd.reader.Read(p)
+	}
+	b.remaining -= int64(n)
+	if b.remaining == 0 {
+		return n, io.EOF
+	}
+	if errors.Is(err, io.EOF) {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (b *body) readChunked(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		consumed, written, err := b.chunked.parse(b.rd.buffered(), p)
+		b.rd.discard(consumed)
+		if err != nil {
+			return written, err
+		}
+		if b.chunked.state == chunkStateDone {
+			// Filled in place so that copies made by WithContext see them.
+			maps.Copy(b.req.Trailers, b.chunked.trailers)
+			return written, io.EOF
+		}
+		if written > 0 {
+			return written, nil
+		}
+		err = b.rd.fill()
+		if errors.Is(err, io.EOF) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (b *body) done() bool {
+	return b.err == io.EOF
+}
+
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+	defer func() { b.closed = true }()
+	if b.err != nil {
+		if b.err == io.EOF {
+			return nil
+		}
+		return b.err
+	}
+	buf := make([]byte, 4096)
+	drained := 0
+	for drained < maxBodyDrain {
+		n, err := b.Read(buf)
+		drained += n
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errors.New("error: request body too large to drain")
+}
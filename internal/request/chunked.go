@@ -0,0 +1,184 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Jud1k/web_server/internal/headers"
+)
+
+const maxChunkSizeLineLength = 4096
+
+type chunkState int
+
+const (
+	chunkStateSize chunkState = iota
+	chunkStateData
+	chunkStateDataEnd
+	chunkStateTrailers
+	chunkStateDone
+)
+
+// chunkedDecoder holds trailers to the same MaxHeaderBytes and
+// MaxHeaderCount limits as the request's header section.
+type chunkedDecoder struct {
+	state        chunkState
+	remaining    int64
+	trailers     headers.Headers
+	trailerBytes int
+	trailerCount int
+	limits       Limits
+}
+
+func newChunkedDecoder(limits Limits) *chunkedDecoder {
+	return &chunkedDecoder{
+		state:    chunkStateSize,
+		trailers: headers.Headers{},
+		limits:   limits,
+	}
+}
+
+func (d *chunkedDecoder) parse(data, dst []byte) (int, int, error) {
+	totalBytesParsed := 0
+	totalBytesWritten := 0
+	for d.state != chunkStateDone {
+		n, written, err := d.parseSingle(data[totalBytesParsed:], dst[totalBytesWritten:])
+		if err != nil {
+			return totalBytesParsed, totalBytesWritten, err
+		}
+		if n == 0 {
+			break
+		}
+		totalBytesParsed += n
+		totalBytesWritten += written
+	}
+	return totalBytesParsed, totalBytesWritten, nil
+}
+
+func (d *chunkedDecoder) parseSingle(data, dst []byte) (int, int, error) {
+	switch d.state {
+	case chunkStateSize:
+		idx := bytes.Index(data, []byte("\r\n"))
+		if idx == -1 {
+			if len(data) > maxChunkSizeLineLength {
+				return 0, 0, wrapError(ErrBadChunk, "chunk size line too long")
+			}
+			return 0, 0, nil
+		}
+		size, err := parseChunkSize(string(data[:idx]))
+		if err != nil {
+			return 0, 0, err
+		}
+		d.remaining = size
+		if size == 0 {
+			d.state = chunkStateTrailers
+		} else {
+			d.state = chunkStateData
+		}
+		return idx + 2, 0, nil
+	case chunkStateData:
+		n := int(min(d.remaining, int64(len(data)), int64(len(dst))))
+		copy(dst, data[:n])
+		d.remaining -= int64(n)
+		if d.remaining == 0 {
+			d.state = chunkStateDataEnd
+		}
+		return n, n, nil
+	case chunkStateDataEnd:
+		if len(data) < 2 {
+			return 0, 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte("\r\n")) {
+			return 0, 0, wrapError(ErrBadChunk, "chunk data not terminated by CRLF")
+		}
+		d.state = chunkStateSize
+		return 2, 0, nil
+	case chunkStateTrailers:
+		n, done, err := d.trailers.Parse(data)
+		if err != nil {
+			return 0, 0, wrapError(ErrBadHeader, err.Error())
+		}
+		if n == 0 {
+			if d.trailerBytes+len(data) > d.limits.MaxHeaderBytes {
+				return 0, 0, ErrHeaderTooLarge
+			}
+			return 0, 0, nil
+		}
+		d.trailerBytes += n
+		if d.trailerBytes > d.limits.MaxHeaderBytes {
+			return 0, 0, ErrHeaderTooLarge
+		}
+		if done {
+			d.state = chunkStateDone
+			return n, 0, nil
+		}
+		d.trailerCount++
+		if d.trailerCount > d.limits.MaxHeaderCount {
+			return 0, 0, ErrHeaderTooLarge
+		}
+		return n, 0, nil
+	case chunkStateDone:
+		return 0, 0, nil
+	default:
+		return 0, 0, errors.New("error: unknown chunk state")
+	}
+}
+
+func parseChunkSize(line string) (int64, error) {
+	sizePart, ext, hasExt := strings.Cut(line, ";")
+	sizePart = strings.TrimRight(sizePart, " \t")
+	if sizePart == "" || len(sizePart) > 15 {
+		return 0, wrapError(ErrBadChunk, "invalid chunk size")
+	}
+	// ParseInt alone would also take a sign, which the chunk-size grammar
+	// (1*HEXDIG) doesn't allow.
+	if strings.TrimLeft(sizePart, "0123456789abcdefABCDEF") != "" {
+		return 0, wrapError(ErrBadChunk, "invalid chunk size")
+	}
+	size, err := strconv.ParseInt(sizePart, 16, 64)
+	if err != nil || size < 0 {
+		return 0, wrapError(ErrBadChunk, "invalid chunk size")
+	}
+	if hasExt && !validChunkExtensions(ext) {
+		return 0, wrapError(ErrBadChunk, "invalid chunk extension")
+	}
+	return size, nil
+}
+
+func validChunkExtensions(ext string) bool {
+	for _, part := range strings.Split(ext, ";") {
+		name, val, hasVal := strings.Cut(strings.TrimSpace(part), "=")
+		if !isToken(strings.TrimSpace(name)) {
+			return false
+		}
+		if !hasVal {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+			continue
+		}
+		if !isToken(val) {
+			return false
+		}
+	}
+	return true
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,29 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrBadRequestLine              = errors.New("error: Not valid format")
+	ErrBadMethod                   = errors.New("error: Invalid HTTP method")
+	ErrBadTarget                   = errors.New("error: invalid request target")
+	ErrBadVersion                  = errors.New("error: invalid HTTP version format")
+	ErrUnsupportedVersion          = errors.New("error: unsupported HTTP version")
+	ErrBadHeader                   = errors.New("error: invalid header field")
+	ErrBadContentLength            = errors.New("error: Invalid Content-Legth value")
+	ErrAmbiguousFraming            = errors.New("error: both Content-Length and Transfer-Encoding present")
+	ErrUnsupportedTransferEncoding = errors.New("error: unsupported Transfer-Encoding")
+	ErrBadChunk                    = errors.New("error: malformed chunked body")
+	ErrRequestLineTooLong          = errors.New("error: request line too long")
+	ErrHeaderTooLarge              = errors.New("error: request header fields too large")
+	ErrBodyTooLarge                = errors.New("error: request body too large")
+	ErrNoCookie                    = errors.New("error: named cookie not present")
+	ErrBodyClosed                  = errors.New("error: read on closed body")
+)
+
+func wrapError(kind error, detail string) error {
+	return fmt.Errorf("%w: %s", kind, strings.TrimPrefix(detail, "error: "))
+}
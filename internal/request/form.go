@@ -0,0 +1,177 @@
+package request
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+)
+
+// DefaultMaxMemory is how much of a multipart form ParseForm keeps in
+// memory; file parts beyond it are spooled to temporary files.
+const DefaultMaxMemory = 32 << 20
+
+// maxFormBytes caps url-encoded bodies, which are always read into memory.
+const maxFormBytes = 10 << 20
+
+var (
+	ErrNotMultipart = errors.New("error: request Content-Type isn't multipart/form-data")
+	ErrBadForm      = errors.New("error: malformed form body")
+	ErrFormTooLarge = errors.New("error: url-encoded form too large")
+	ErrMissingFile  = errors.New("error: no such file in form")
+)
+
+// formFiles is shared by every shallow copy of a request so the server can
+// remove the temporary files no matter which copy parsed the form.
+type formFiles struct {
+	forms []*multipart.Form
+}
+
+// ParseForm fills Form from the query string and, for POST, PUT and PATCH
+// requests, PostForm from an url-encoded or multipart body. Calling it
+// again is a no-op.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+	mediaType, params, err := r.mediaType()
+	if err != nil {
+		return err
+	}
+	if mediaType == "multipart/form-data" {
+		return r.parseMultipart(params, DefaultMaxMemory)
+	}
+	return r.parseForm(mediaType)
+}
+
+// ParseMultipartForm parses a multipart/form-data body, keeping up to
+// maxMemory bytes of file parts in memory and spooling the rest to disk.
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+	mediaType, params, err := r.mediaType()
+	if err != nil {
+		return err
+	}
+	if mediaType != "multipart/form-data" {
+		return ErrNotMultipart
+	}
+	return r.parseMultipart(params, maxMemory)
+}
+
+func (r *Request) FormValue(name string) string {
+	if r.Form == nil {
+		r.ParseForm()
+	}
+	return r.Form.Get(name)
+}
+
+func (r *Request) PostFormValue(name string) string {
+	if r.PostForm == nil {
+		r.ParseForm()
+	}
+	return r.PostForm.Get(name)
+}
+
+func (r *Request) FormFile(name string) (multipart.File, *multipart.FileHeader, error) {
+	if r.MultipartForm == nil {
+		if err := r.ParseMultipartForm(DefaultMaxMemory); err != nil {
+			return nil, nil, err
+		}
+	}
+	files := r.MultipartForm.File[name]
+	if len(files) == 0 {
+		return nil, nil, ErrMissingFile
+	}
+	f, err := files[0].Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, files[0], nil
+}
+
+// RemoveFormFiles deletes any temporary files created while parsing
+// multipart forms. The server calls it once the handler has returned.
+func (r *Request) RemoveFormFiles() error {
+	if r.files == nil {
+		return nil
+	}
+	var errs []error
+	for _, form := range r.files.forms {
+		errs = append(errs, form.RemoveAll())
+	}
+	r.files.forms = nil
+	return errors.Join(errs...)
+}
+
+func (r *Request) mediaType() (string, map[string]string, error) {
+	contentType := r.Headers.Get("Content-Type")
+	if contentType == "" {
+		return "", nil, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, wrapError(ErrBadForm, err.Error())
+	}
+	return mediaType, params, nil
+}
+
+func (r *Request) parseForm(mediaType string) error {
+	r.PostForm = url.Values{}
+	if r.hasFormBody() && mediaType == "application/x-www-form-urlencoded" {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxFormBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > maxFormBytes {
+			return ErrFormTooLarge
+		}
+		r.PostForm, err = url.ParseQuery(string(data))
+		if err != nil {
+			return wrapError(ErrBadForm, err.Error())
+		}
+	}
+	r.Form = r.mergedForm()
+	return nil
+}
+
+func (r *Request) parseMultipart(params map[string]string, maxMemory int64) error {
+	boundary := params["boundary"]
+	if boundary == "" {
+		return wrapError(ErrBadForm, "missing multipart boundary")
+	}
+	form, err := multipart.NewReader(r.Body, boundary).ReadForm(maxMemory)
+	if err != nil {
+		return wrapError(ErrBadForm, err.Error())
+	}
+	if r.files != nil {
+		r.files.forms = append(r.files.forms, form)
+	}
+	r.MultipartForm = form
+	r.PostForm = url.Values(form.Value)
+	r.Form = r.mergedForm()
+	return nil
+}
+
+// mergedForm returns the body values followed by the query values.
+func (r *Request) mergedForm() url.Values {
+	form := url.Values{}
+	for key, vals := range r.PostForm {
+		form[key] = append(form[key], vals...)
+	}
+	for key, vals := range r.Query() {
+		form[key] = append(form[key], vals...)
+	}
+	return form
+}
+
+func (r *Request) hasFormBody() bool {
+	switch r.RequestLine.Method {
+	case "POST", "PUT", "PATCH":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,32 @@
+package request
+
+// Zero fields fall back to the defaults, except MaxBodyBytes where zero
+// means the body size is not limited.
+type Limits struct {
+	MaxRequestLineBytes int
+	MaxHeaderBytes      int
+	MaxHeaderCount      int
+	MaxBodyBytes        int64
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxRequestLineBytes: 8 << 10,
+		MaxHeaderBytes:      1 << 20,
+		MaxHeaderCount:      100,
+	}
+}
+
+func (l Limits) WithDefaults() Limits {
+	defaults := DefaultLimits()
+	if l.MaxRequestLineBytes <= 0 {
+		l.MaxRequestLineBytes = defaults.MaxRequestLineBytes
+	}
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = defaults.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount <= 0 {
+		l.MaxHeaderCount = defaults.MaxHeaderCount
+	}
+	return l
+}
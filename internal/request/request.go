@@ -2,11 +2,16 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"io"
-	"strconv"
+	"mime/multipart"
+	"net/url"
 	"strings"
 
+	"github.com/Jud1k/web_server/internal/cookie"
 	"github.com/Jud1k/web_server/internal/headers"
 )
 
@@ -17,15 +22,27 @@ type parseState int
 const (
 	stateInit parseState = iota
 	stateParsingHeaders
-	stateParsingBody
 	stateDone
 )
 
 type Request struct {
-	RequestLine RequestLine
-	Headers     headers.Headers
-	Body        []byte
-	State       parseState
+	RequestLine   RequestLine
+	Target        Target
+	Headers       headers.Headers
+	Body          io.ReadCloser
+	Trailers      headers.Headers
+	Form          url.Values
+	PostForm      url.Values
+	MultipartForm *multipart.Form
+	TLS           *tls.ConnectionState
+	State         parseState
+	limits        Limits
+	headerBytes   int
+	headerCount   int
+	pathValues    map[string]string
+	query         url.Values
+	files         *formFiles
+	ctx           context.Context
 }
 
 type RequestLine struct {
@@ -37,11 +54,12 @@ type RequestLine struct {
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
 	for r.State != stateDone {
+		prevState := r.State
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return totalBytesParsed, err
 		}
-		if n == 0 {
+		if n == 0 && r.State == prevState {
 			break
 		}
 		totalBytesParsed += n
@@ -57,41 +75,46 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, err
 		}
 		if n == 0 {
+			if len(data) > r.limits.MaxRequestLineBytes {
+				return 0, ErrRequestLineTooLong
+			}
 			return 0, nil
 		}
+		if n-2 > r.limits.MaxRequestLineBytes {
+			return 0, ErrRequestLineTooLong
+		}
+		target, err := parseTarget(rl.Method, rl.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
 		r.RequestLine = *rl
+		r.Target = target
 		r.State = stateParsingHeaders
 		return n, nil
 	case stateParsingHeaders:
 		n, done, err := r.Headers.Parse(data)
 		if err != nil {
-			return 0, err
+			return 0, wrapError(ErrBadHeader, err.Error())
 		}
 		if n == 0 {
+			if r.headerBytes+len(data) > r.limits.MaxHeaderBytes {
+				return 0, ErrHeaderTooLarge
+			}
 			return 0, nil
 		}
-		if done {
-			r.State = stateParsingBody
+		r.headerBytes += n
+		if r.headerBytes > r.limits.MaxHeaderBytes {
+			return 0, ErrHeaderTooLarge
 		}
-		return n, nil
-	case stateParsingBody:
-		contentLen := r.Headers.Get("Content-Length")
-		if contentLen == "" {
+		if done {
 			r.State = stateDone
-			return len(data), nil
-		}
-		contentLenInt, err := strconv.Atoi(contentLen)
-		if err != nil {
-			return 0, errors.New("error: Invalid Content-Legth value")
+			return n, nil
 		}
-		r.Body = append(r.Body, data...)
-		if len(r.Body) > contentLenInt {
-			return 0, errors.New("error: Length of data bigger then Content-Length")
+		r.headerCount++
+		if r.headerCount > r.limits.MaxHeaderCount {
+			return 0, ErrHeaderTooLarge
 		}
-		if len(r.Body) == contentLenInt {
-			r.State = stateDone
-		}
-		return len(data), nil
+		return n, nil
 	case stateDone:
 		return 0, nil
 	default:
@@ -99,34 +122,232 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
+type Reader struct {
+	reader      io.Reader
+	buffer      []byte
+	readToIndex int
+	body        *body
+	limits      Limits
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return NewReaderWithLimits(reader, DefaultLimits())
+}
+
+func NewReaderWithLimits(reader io.Reader, limits Limits) *Reader {
+	return &Reader{
+		reader: reader,
+		buffer: make([]byte, bufferSize),
+		limits: limits.WithDefaults(),
+	}
+}
+
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	readToIndex := 0
-	buffer := make([]byte, bufferSize)
-	r := &Request{State: stateInit, Headers: headers.Headers{}}
-	for r.State != stateDone {
-		if readToIndex == len(buffer) {
-			doubleBuf(&buffer)
+	return NewReader(reader).ReadRequest()
+}
+
+func (rd *Reader) ReadRequest() (*Request, error) {
+	if rd.body != nil && !rd.body.done() {
+		return nil, errors.New("error: previous request body not fully read")
+	}
+	r := &Request{State: stateInit, Headers: headers.Headers{}, Trailers: headers.Headers{}, limits: rd.limits, files: &formFiles{}}
+	for {
+		numBytesParsed, err := r.parse(rd.buffered())
+		if err != nil {
+			return nil, err
+		}
+		rd.discard(numBytesParsed)
+		if r.State == stateDone {
+			break
 		}
-		numBytesRead, err := reader.Read(buffer[readToIndex:])
+		err = rd.fill()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				r.State = stateDone
-				break
+				if r.State == stateInit && len(rd.buffered()) == 0 {
+					return nil, io.EOF
+				}
+				return nil, io.ErrUnexpectedEOF
 			}
 			return nil, err
 		}
-		readToIndex += numBytesRead
-		numBytesParsed, err := r.parse(buffer[:readToIndex])
+	}
+	b, err := newBody(rd, r)
+	if err != nil {
+		return nil, err
+	}
+	rd.body = b
+	r.Body = b
+	return r, nil
+}
+
+// New builds a request whose head was decoded by another protocol, such as
+// an HTTP/2 HEADERS frame. The target is validated like a request line's.
+func New(method, target, version string, h headers.Headers, body io.ReadCloser) (*Request, error) {
+	if !isValidMethod(method) {
+		return nil, ErrBadMethod
+	}
+	t, err := parseTarget(method, target)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{
+		RequestLine: RequestLine{Method: method, RequestTarget: target, HttpVersion: version},
+		Target:      t,
+		Headers:     h,
+		Trailers:    headers.Headers{},
+		Body:        body,
+		State:       stateDone,
+		limits:      DefaultLimits(),
+		files:       &formFiles{},
+	}, nil
+}
+
+// HasPrefix reports whether the next bytes on the connection are prefix,
+// reading no more than needed to tell.
+func (rd *Reader) HasPrefix(prefix []byte) (bool, error) {
+	for {
+		buf := rd.buffered()
+		n := min(len(buf), len(prefix))
+		if !bytes.Equal(buf[:n], prefix[:n]) {
+			return false, nil
+		}
+		if n == len(prefix) {
+			return true, nil
+		}
+		if err := rd.fill(); err != nil {
+			return false, err
+		}
+	}
+}
+
+// Detach hands the connection over to another protocol. The returned
+// reader yields the bytes already buffered and then reads from the
+// connection; rd must not be used afterwards.
+func (rd *Reader) Detach() io.Reader {
+	buffered := bytes.Clone(rd.buffered())
+	rd.readToIndex = 0
+	return io.MultiReader(bytes.NewReader(buffered), rd.reader)
+}
+
+func (rd *Reader) Wait() error {
+	if rd.readToIndex > 0 {
+		return nil
+	}
+	return rd.fill()
+}
+
+func (rd *Reader) buffered() []byte {
+	return rd.buffer[:rd.readToIndex]
+}
+
+func (rd *Reader) discard(n int) {
+	copy(rd.buffer, rd.buffer[n:rd.readToIndex])
+	rd.readToIndex -= n
+}
+
+func (rd *Reader) fill() error {
+	if rd.readToIndex == len(rd.buffer) {
+		doubleBuf(&rd.buffer)
+	}
+	for {
+		numBytesRead, err := rd.reader.Read(rd.buffer[rd.readToIndex:])
+		rd.readToIndex += numBytesRead
+		if numBytesRead > 0 {
+			return nil
+		}
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if numBytesParsed == 0 {
-			continue
+	}
+}
+
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r with its context replaced. The
+// copy shares the body, trailers and parsed form files with r.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// Path returns the percent-decoded request path.
+func (r *Request) Path() string {
+	return r.Target.Path
+}
+
+// RawPath returns the request path as sent by the client, still escaped.
+func (r *Request) RawPath() string {
+	return r.Target.RawPath
+}
+
+func (r *Request) Query() url.Values {
+	if r.query == nil {
+		r.query, _ = url.ParseQuery(r.Target.RawQuery)
+	}
+	return r.query
+}
+
+// PeerCertificate returns the client certificate if it was verified
+// against the server's client CA pool, and nil otherwise.
+func (r *Request) PeerCertificate() *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// Cookies returns the cookies from every Cookie header in the order sent.
+func (r *Request) Cookies() []*cookie.Cookie {
+	var cookies []*cookie.Cookie
+	for _, header := range r.Headers.Values("Cookie") {
+		cookies = append(cookies, cookie.Parse(header)...)
+	}
+	return cookies
+}
+
+func (r *Request) Cookie(name string) (*cookie.Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
 		}
-		copy(buffer, buffer[numBytesParsed:readToIndex])
-		readToIndex -= numBytesParsed
 	}
-	return r, nil
+	return nil, ErrNoCookie
+}
+
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+	r.pathValues[name] = value
+}
+
+// WantsClose reports whether the connection should be closed after this
+// request. HTTP/1.0 clients have to ask for keep-alive explicitly.
+func (r *Request) WantsClose() bool {
+	if !r.ProtoAtLeast(1, 1) {
+		return !r.Headers.HasToken("Connection", "keep-alive")
+	}
+	return r.Headers.HasToken("Connection", "close")
+}
+
+// ProtoAtLeast reports whether the request was made with HTTP major.minor
+// or later.
+func (r *Request) ProtoAtLeast(major, minor int) bool {
+	reqMajor, reqMinor, ok := parseVersion(r.RequestLine.HttpVersion)
+	if !ok {
+		return false
+	}
+	return reqMajor > major || reqMajor == major && reqMinor >= minor
 }
 
 func doubleBuf(buffer *[]byte) {
@@ -144,21 +365,24 @@ func parseRequestLine(data []byte) (int, *RequestLine, error) {
 	consumed := idx + 2
 	partsLine := strings.Split(string(line), " ")
 	if len(partsLine) != 3 {
-		return 0, nil, errors.New("error: Not valid format")
+		return 0, nil, ErrBadRequestLine
 	}
 	method := partsLine[0]
 	if !isValidMethod(method) {
-		return 0, nil, errors.New("error: Invalid HTTP method")
+		return 0, nil, ErrBadMethod
 	}
-	//TODO: Add validation to target
 	target := partsLine[1]
 	if !strings.HasPrefix(partsLine[2], "HTTP/") {
-		return 0, nil, errors.New("error: invalid HTTP version format")
+		return 0, nil, ErrBadVersion
 	}
 
 	version := strings.TrimPrefix(partsLine[2], "HTTP/")
-	if version != "1.1" {
-		return 0, nil, errors.New("error: unsupported HTTP version")
+	major, _, ok := parseVersion(version)
+	if !ok || len(version) != 3 {
+		return 0, nil, ErrBadVersion
+	}
+	if major != 1 {
+		return 0, nil, ErrUnsupportedVersion
 	}
 	req := RequestLine{
 		Method:        method,
@@ -169,6 +393,19 @@ func parseRequestLine(data []byte) (int, *RequestLine, error) {
 
 }
 
+// parseVersion parses "major.minor" with single digits as in RFC 9112;
+// HTTP/2 requests built by New carry just "2".
+func parseVersion(version string) (major, minor int, ok bool) {
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	switch {
+	case len(version) == 1 && isDigit(version[0]):
+		return int(version[0] - '0'), 0, true
+	case len(version) == 3 && isDigit(version[0]) && version[1] == '.' && isDigit(version[2]):
+		return int(version[0] - '0'), int(version[2] - '0'), true
+	}
+	return 0, 0, false
+}
+
 func isValidMethod(str string) bool {
 	switch str {
 	case "GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "HEAD", "TRACE", "CONNECT":
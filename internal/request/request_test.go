@@ -1,7 +1,12 @@
 package request_test
 
 import (
+	"bytes"
 	"io"
+	"mime/multipart"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/Jud1k/web_server/internal/request"
@@ -44,8 +49,12 @@ func TestRequestLine_AllValidMethod(t *testing.T) {
 
 	for _, method := range methods {
 		t.Run(method, func(t *testing.T) {
+			target := "/"
+			if method == "CONNECT" {
+				target = "localhost:42069"
+			}
 			reader := &chunkReader{
-				data:            method + " / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+				data:            method + " " + target + " HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
 				numBytesPerRead: 3,
 			}
 			r, err := request.RequestFromReader(reader)
@@ -111,9 +120,9 @@ func TestHeadersSuccess(t *testing.T) {
 	r, err := request.RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 }
 
 func TestIvalidHeader(t *testing.T) {
@@ -151,7 +160,8 @@ func TestDuplicateHeadersSuccess(t *testing.T) {
 	r, err := request.RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, localhost:12345", r.Headers["host"])
+	assert.Equal(t, "localhost:42069, localhost:12345", r.Headers.Get("host"))
+	assert.Equal(t, []string{"localhost:42069", "localhost:12345"}, r.Headers.Values("host"))
 }
 
 func TestBodySuccess(t *testing.T) {
@@ -166,21 +176,25 @@ func TestBodySuccess(t *testing.T) {
 	r, err := request.RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
-}
-
-// func TestWrongContentLen(t *testing.T) {
-// 	reader := &chunkReader{
-// 	data: "POST /submit HTTP/1.1\r\n" +
-// 		"Host: localhost:42069\r\n" +
-// 		"Content-Length: 20\r\n" +
-// 		"\r\n" +
-// 		"partial content",
-// 	numBytesPerRead: 3,
-// 	}
-// 	_, err := request.RequestFromReader(reader)
-// 	require.Error(t, err)
-// }
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+}
+
+func TestWrongContentLen(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n" +
+			"partial content",
+		numBytesPerRead: 3,
+	}
+	r, err := request.RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
 
 func TestEmptyBodySuccess(t *testing.T) {
 	reader := &chunkReader{
@@ -192,20 +206,24 @@ func TestEmptyBodySuccess(t *testing.T) {
 	r, err := request.RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, []byte(nil), r.Body)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
 }
 
-// func TestEmptyBodyWithContentLen(t *testing.T) {
-// 	reader := &chunkReader{
-// 	data: "POST /submit HTTP/1.1\r\n" +
-// 		"Host: localhost:42069\r\n" +
-// 		"Content-Length: 20\r\n" +
-// 		"\r\n",
-// 	numBytesPerRead: 3,
-// 	}
-// 	_, err := request.RequestFromReader(reader)
-// 	require.Error(t, err)
-// }
+func TestEmptyBodyWithContentLen(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := request.RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
 
 func TestNoContentLenWithBody(t *testing.T) {
 	reader := &chunkReader{
@@ -218,5 +236,446 @@ func TestNoContentLenWithBody(t *testing.T) {
 	r, err := request.RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, []byte(nil), r.Body)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	reader := request.NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n" +
+			"GET /third HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 64,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestChunkedBodySuccess(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6;name=value\r\n" +
+			"hello \r\n" +
+			"6\r\n" +
+			"world!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := request.RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+	assert.Equal(t, "abc", r.Trailers.Get("X-Checksum"))
+}
+
+func TestChunkedBodyInvalidSize(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\n" +
+			"hello\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := request.RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+}
+
+func TestChunkedBodySignedSize(t *testing.T) {
+	for _, size := range []string{"+3", "-3", "0x3", " 3"} {
+		t.Run(size, func(t *testing.T) {
+			reader := &chunkReader{
+				data:            "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + size + "\r\nabc\r\n0\r\n\r\n",
+				numBytesPerRead: 3,
+			}
+			r, err := request.RequestFromReader(reader)
+			require.NoError(t, err)
+			_, err = io.ReadAll(r.Body)
+			require.ErrorIs(t, err, request.ErrBadChunk)
+		})
+	}
+}
+
+func TestChunkedBodyMissingCRLF(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello!!\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := request.RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+}
+
+func TestContentLengthWithTransferEncoding(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err := request.RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestUnreadBodyDrainedBeforeNextRequest(t *testing.T) {
+	reader := request.NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n0\r\n\r\n" +
+			"GET /second HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = reader.ReadRequest()
+	require.Error(t, err)
+
+	require.NoError(t, r.Body.Close())
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+}
+
+func TestRequestLineTooLong(t *testing.T) {
+	reader := request.NewReaderWithLimits(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}, request.Limits{MaxRequestLineBytes: 32})
+	_, err := reader.ReadRequest()
+	require.ErrorIs(t, err, request.ErrRequestLineTooLong)
+}
+
+func TestRequestLineWithoutCRLFTooLong(t *testing.T) {
+	reader := request.NewReaderWithLimits(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 1024),
+		numBytesPerRead: 7,
+	}, request.Limits{MaxRequestLineBytes: 32})
+	_, err := reader.ReadRequest()
+	require.ErrorIs(t, err, request.ErrRequestLineTooLong)
+}
+
+func TestHeaderBytesTooLarge(t *testing.T) {
+	reader := request.NewReaderWithLimits(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", 128) + "\r\n\r\n",
+		numBytesPerRead: 3,
+	}, request.Limits{MaxHeaderBytes: 64})
+	_, err := reader.ReadRequest()
+	require.ErrorIs(t, err, request.ErrHeaderTooLarge)
+}
+
+func TestHeaderCountTooLarge(t *testing.T) {
+	reader := request.NewReaderWithLimits(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n",
+		numBytesPerRead: 3,
+	}, request.Limits{MaxHeaderCount: 2})
+	_, err := reader.ReadRequest()
+	require.ErrorIs(t, err, request.ErrHeaderTooLarge)
+}
+
+func TestContentLengthTooLarge(t *testing.T) {
+	reader := request.NewReaderWithLimits(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 11\r\n\r\nhello world",
+		numBytesPerRead: 3,
+	}, request.Limits{MaxBodyBytes: 10})
+	_, err := reader.ReadRequest()
+	require.ErrorIs(t, err, request.ErrBodyTooLarge)
+}
+
+func TestChunkedBodyTooLarge(t *testing.T) {
+	reader := request.NewReaderWithLimits(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n5\r\nworld\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}, request.Limits{MaxBodyBytes: 10})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, request.ErrBodyTooLarge)
+}
+
+func TestChunkedTrailersCountTowardsLimits(t *testing.T) {
+	// The long target grows the read buffer so that the trailers arrive
+	// in a single read as complete lines.
+	target := "/" + strings.Repeat("a", 200)
+	tests := []struct {
+		name     string
+		trailers string
+		limits   request.Limits
+	}{
+		{"count", "A: 1\r\nB: 2\r\nC: 3\r\n", request.Limits{MaxHeaderCount: 2}},
+		{"bytes", strings.Repeat("X-Trailer: "+strings.Repeat("a", 20)+"\r\n", 4), request.Limits{MaxHeaderBytes: 64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := request.NewReaderWithLimits(&chunkReader{
+				data:            "POST " + target + " HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n" + tt.trailers + "\r\n",
+				numBytesPerRead: 1024,
+			}, tt.limits)
+			r, err := reader.ReadRequest()
+			require.NoError(t, err)
+			_, err = io.ReadAll(r.Body)
+			require.ErrorIs(t, err, request.ErrHeaderTooLarge)
+		})
+	}
+}
+
+func TestTypedParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"bad request line", "GET /\r\n\r\n", request.ErrBadRequestLine},
+		{"bad method", "get / HTTP/1.1\r\n\r\n", request.ErrBadMethod},
+		{"bad version", "GET / HTTX/1.1\r\n\r\n", request.ErrBadVersion},
+		{"malformed version", "GET / HTTP/1\r\n\r\n", request.ErrBadVersion},
+		{"unsupported version", "GET / HTTP/2.0\r\n\r\n", request.ErrUnsupportedVersion},
+		{"unknown major version", "GET / HTTP/3.0\r\n\r\n", request.ErrUnsupportedVersion},
+		{"bad header", "GET / HTTP/1.1\r\nH@st: localhost\r\n\r\n", request.ErrBadHeader},
+		{"ambiguous framing", "POST / HTTP/1.1\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n", request.ErrAmbiguousFraming},
+		{"unsupported transfer encoding", "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", request.ErrUnsupportedTransferEncoding},
+		{"bad content length", "POST / HTTP/1.1\r\nContent-Length: abc\r\n\r\n", request.ErrBadContentLength},
+		{"signed content length", "POST / HTTP/1.1\r\nContent-Length: +3\r\n\r\nabc", request.ErrBadContentLength},
+		{"negative content length", "POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n", request.ErrBadContentLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := request.RequestFromReader(&chunkReader{data: tt.data, numBytesPerRead: 3})
+			require.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestTargetForms(t *testing.T) {
+	tests := []struct {
+		line string
+		want request.Target
+	}{
+		{
+			line: "GET /a%20b/c?page=2&q=x%26y HTTP/1.1",
+			want: request.Target{Form: request.FormOrigin, RawPath: "/a%20b/c", Path: "/a b/c", RawQuery: "page=2&q=x%26y"},
+		},
+		{
+			line: "GET http://example.com:8080/x?y=1 HTTP/1.1",
+			want: request.Target{Form: request.FormAbsolute, Scheme: "http", Host: "example.com:8080", RawPath: "/x", Path: "/x", RawQuery: "y=1"},
+		},
+		{
+			line: "GET https://example.com HTTP/1.1",
+			want: request.Target{Form: request.FormAbsolute, Scheme: "https", Host: "example.com", RawPath: "/", Path: "/"},
+		},
+		{
+			line: "CONNECT example.com:443 HTTP/1.1",
+			want: request.Target{Form: request.FormAuthority, Host: "example.com:443"},
+		},
+		{
+			line: "OPTIONS * HTTP/1.1",
+			want: request.Target{Form: request.FormAsterisk, RawPath: "*", Path: "*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			r, err := request.RequestFromReader(strings.NewReader(tt.line + "\r\nHost: localhost\r\n\r\n"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, r.Target)
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	r, err := request.RequestFromReader(strings.NewReader("GET /search?page=2&tag=a&tag=b&q=x%26y HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/search", r.Path())
+	assert.Equal(t, "2", r.Query().Get("page"))
+	assert.Equal(t, []string{"a", "b"}, r.Query()["tag"])
+	assert.Equal(t, "x&y", r.Query().Get("q"))
+	assert.Equal(t, "", r.Query().Get("missing"))
+}
+
+func TestInvalidTargets(t *testing.T) {
+	targets := []string{
+		"/a%2",
+		"/a%zz",
+		"/q?x=%G1",
+		"/a\x01b",
+		"/a\x7fb",
+		"/a#frag",
+		"*",
+		"example.com",
+		"http:///nohost",
+	}
+	for _, target := range targets {
+		t.Run(target, func(t *testing.T) {
+			_, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+			require.ErrorIs(t, err, request.ErrBadTarget)
+		})
+	}
+	_, err := request.RequestFromReader(strings.NewReader("CONNECT /path HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.ErrorIs(t, err, request.ErrBadTarget)
+}
+
+func TestParseURLEncodedForm(t *testing.T) {
+	body := "name=gopher&tag=a&tag=b+c"
+	r, err := request.RequestFromReader(strings.NewReader("POST /submit?tag=q HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "gopher", r.FormValue("name"))
+	assert.Equal(t, []string{"a", "b c"}, r.PostForm["tag"])
+	assert.Equal(t, []string{"a", "b c", "q"}, r.Form["tag"])
+}
+
+func TestParseFormIgnoresBodyOnGet(t *testing.T) {
+	r, err := request.RequestFromReader(strings.NewReader("GET /?page=3 HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\n" +
+		"Content-Length: 3\r\n\r\na=1"))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "3", r.FormValue("page"))
+	assert.Equal(t, "", r.FormValue("a"))
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	require.NoError(t, mw.WriteField("title", "holiday"))
+	fw, err := mw.CreateFormFile("upload", "photo.jpg")
+	require.NoError(t, err)
+	content := strings.Repeat("x", 4096)
+	_, err = fw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	r, err := request.RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Type: " + mw.FormDataContentType() + "\r\n" +
+		"Content-Length: " + strconv.Itoa(buf.Len()) + "\r\n\r\n" + buf.String()))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseMultipartForm(1024))
+	assert.Equal(t, "holiday", r.FormValue("title"))
+
+	f, fh, err := r.FormFile("upload")
+	require.NoError(t, err)
+	assert.Equal(t, "photo.jpg", fh.Filename)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+	osFile, ok := f.(*os.File)
+	require.True(t, ok, "file part over the memory threshold should be spooled to disk")
+	require.NoError(t, f.Close())
+
+	require.NoError(t, r.RemoveFormFiles())
+	_, err = os.Stat(osFile.Name())
+	assert.True(t, os.IsNotExist(err))
+
+	_, _, err = r.FormFile("missing")
+	assert.ErrorIs(t, err, request.ErrMissingFile)
+}
+
+func TestParseMultipartFormRejectsOtherTypes(t *testing.T) {
+	r, err := request.RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.ParseMultipartForm(1024), request.ErrNotMultipart)
+}
+
+func TestCookies(t *testing.T) {
+	r, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Cookie: session=abc; theme=dark\r\n" +
+		"Cookie: lang=en\r\n\r\n"))
+	require.NoError(t, err)
+	cookies := r.Cookies()
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "lang", cookies[2].Name)
+
+	c, err := r.Cookie("theme")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", c.Value)
+
+	_, err = r.Cookie("missing")
+	assert.ErrorIs(t, err, request.ErrNoCookie)
+}
+
+func TestHTTP10Requests(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantClose bool
+	}{
+		{"default close", "GET / HTTP/1.0\r\n\r\n", true},
+		{"keep-alive", "GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n", false},
+		{"http/1.1 default keep-alive", "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", false},
+		{"http/1.1 close", "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := request.RequestFromReader(&chunkReader{data: tt.data, numBytesPerRead: 3})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantClose, r.WantsClose())
+		})
+	}
+
+	r, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.True(t, r.ProtoAtLeast(1, 0))
+	assert.False(t, r.ProtoAtLeast(1, 1))
+}
+
+func TestHTTP10RequestWithTransferEncoding(t *testing.T) {
+	_, err := request.RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.ErrorIs(t, err, request.ErrAmbiguousFraming)
 }
@@ -0,0 +1,132 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type TargetForm int
+
+const (
+	FormOrigin TargetForm = iota
+	FormAbsolute
+	FormAuthority
+	FormAsterisk
+)
+
+// Target is the parsed request-target (RFC 9112 section 3.2). RawPath keeps
+// the path exactly as sent, Path is its percent-decoded form.
+type Target struct {
+	Form     TargetForm
+	Scheme   string
+	Host     string
+	RawPath  string
+	Path     string
+	RawQuery string
+}
+
+func parseTarget(method, raw string) (Target, error) {
+	if raw == "" {
+		return Target{}, wrapError(ErrBadTarget, "empty request target")
+	}
+	for i := 0; i < len(raw); i++ {
+		if raw[i] < 0x21 || raw[i] == 0x7f {
+			return Target{}, wrapError(ErrBadTarget, fmt.Sprintf("control character at offset %d", i))
+		}
+	}
+	if strings.Contains(raw, "#") {
+		return Target{}, wrapError(ErrBadTarget, "fragment not allowed")
+	}
+	if err := checkEscapes(raw); err != nil {
+		return Target{}, err
+	}
+
+	switch {
+	case method == "CONNECT":
+		return parseAuthorityForm(raw)
+	case raw == "*":
+		if method != "OPTIONS" {
+			return Target{}, wrapError(ErrBadTarget, "asterisk-form is only allowed for OPTIONS")
+		}
+		return Target{Form: FormAsterisk, RawPath: "*", Path: "*"}, nil
+	case strings.HasPrefix(raw, "/"):
+		t := Target{Form: FormOrigin}
+		t.RawPath, t.RawQuery, _ = strings.Cut(raw, "?")
+		return t, t.decodePath()
+	default:
+		return parseAbsoluteForm(raw)
+	}
+}
+
+func parseAuthorityForm(raw string) (Target, error) {
+	host, port, ok := strings.Cut(raw, ":")
+	if !ok || host == "" || port == "" || strings.ContainsAny(raw, "/?@") {
+		return Target{}, wrapError(ErrBadTarget, "CONNECT requires host:port")
+	}
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return Target{}, wrapError(ErrBadTarget, "invalid port")
+		}
+	}
+	return Target{Form: FormAuthority, Host: raw}, nil
+}
+
+func parseAbsoluteForm(raw string) (Target, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok || !validScheme(scheme) {
+		return Target{}, wrapError(ErrBadTarget, "unrecognized target form")
+	}
+	t := Target{Form: FormAbsolute, Scheme: strings.ToLower(scheme)}
+	rest, t.RawQuery, _ = strings.Cut(rest, "?")
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		t.Host, t.RawPath = rest[:i], rest[i:]
+	} else {
+		t.Host, t.RawPath = rest, "/"
+	}
+	if t.Host == "" || strings.Contains(t.Host, "@") {
+		return Target{}, wrapError(ErrBadTarget, "invalid authority")
+	}
+	return t, t.decodePath()
+}
+
+func (t *Target) decodePath() error {
+	path, err := url.PathUnescape(t.RawPath)
+	if err != nil {
+		return wrapError(ErrBadTarget, err.Error())
+	}
+	t.Path = path
+	return nil
+}
+
+func validScheme(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func checkEscapes(s string) error {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return wrapError(ErrBadTarget, fmt.Sprintf("invalid percent-encoding at offset %d", i))
+		}
+		i += 2
+	}
+	return nil
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
@@ -1,21 +1,29 @@
 package response
 
 import (
-	"bytes"
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
-	"maps"
+	"net"
+	"slices"
 	"strconv"
+	"strings"
 
+	"github.com/Jud1k/web_server/internal/cookie"
 	"github.com/Jud1k/web_server/internal/headers"
 )
 
-type StatusCode int
+// Bodies up to this size are held back so that Content-Length can be
+// computed; anything bigger is streamed with chunked encoding.
+const bodyBufferSize = 4096
 
-const (
-	StatusCodeOk            StatusCode = 200
-	StatusCodeBadRequest    StatusCode = 400
-	StatusCodeInternalError StatusCode = 500
+var (
+	ErrInconsistentFraming = errors.New("error: inconsistent response framing")
+	ErrContentLength       = errors.New("error: body length does not match Content-Length")
+	ErrBodyNotAllowed      = errors.New("error: response status does not allow a body")
+	ErrNotHijackable       = errors.New("error: connection cannot be hijacked")
+	ErrHijacked            = errors.New("error: connection has been hijacked")
 )
 
 type writerState int
@@ -24,111 +32,587 @@ const (
 	stateInitial writerState = iota
 	stateStatusWritten
 	stateHeadersWritten
+	stateWritingBody
 	stateBodyWritten
+	stateTrailersWritten
+)
+
+type framing int
+
+const (
+	framingUnknown framing = iota
+	framingLength
+	framingChunked
+	framingNone
+	// framingClose delimits the body by closing the connection, for
+	// HTTP/1.0 clients that don't understand chunked encoding.
+	framingClose
 )
 
+// Stream carries a response over a protocol that does its own framing,
+// such as an HTTP/2 stream. The Writer still decides Content-Length and
+// keeps the same API for handlers.
+type Stream interface {
+	WriteHeaders(statusCode StatusCode, h headers.Headers) error
+	Write(p []byte) (int, error)
+	// Close ends the response, sending trailers if there are any.
+	Close(trailers headers.Headers) error
+	Flush() error
+}
+
 type Writer struct {
-	state   writerState
-	buff    bytes.Buffer
-	headers headers.Headers
+	state         writerState
+	buff          *bufio.Writer
+	stream        Stream
+	headers       headers.Headers
+	statusCode    StatusCode
+	reason        string
+	closeConn     bool
+	http10        bool
+	head          bool
+	hijack        func() (net.Conn, io.Reader)
+	hijacked      bool
+	onHeaders     []func(headers.Headers)
+	committed     bool
+	framing       framing
+	body          []byte
+	contentLength int64
+	written       int64
+	err           error
 }
 
-func NewWriter() *Writer {
+func NewWriter(writer io.Writer) *Writer {
 	return &Writer{
 		state:   stateInitial,
+		buff:    bufio.NewWriter(writer),
 		headers: make(headers.Headers),
 	}
 }
 
-func (w *Writer) WriteTo(writer io.Writer) (n int64, err error) {
-	return w.buff.WriteTo(writer)
+func NewStreamWriter(stream Stream) *Writer {
+	return &Writer{
+		state:   stateInitial,
+		stream:  stream,
+		headers: make(headers.Headers),
+	}
 }
 
-func (w *Writer) Bytes() []byte {
-	return w.buff.Bytes()
+// SetHTTP10 marks the response as going to an HTTP/1.0 client. Bodies of
+// unknown length are then sent without chunked encoding and end when the
+// connection closes, and trailers are dropped. A response that keeps the
+// connection open says so with "Connection: keep-alive".
+func (w *Writer) SetHTTP10() {
+	w.http10 = true
+}
+
+// SetHEAD marks the response as answering a HEAD request. Handlers can
+// write the same body as for GET: it is counted for Content-Length but
+// never sent.
+func (w *Writer) SetHEAD() {
+	w.head = true
+}
+
+// SetHijacker lets handlers take over the connection with Hijack. fn
+// returns the connection and a reader that yields any bytes already
+// buffered from it before reading the connection itself.
+func (w *Writer) SetHijacker(fn func() (net.Conn, io.Reader)) {
+	w.hijack = fn
+}
+
+func (w *Writer) Hijackable() bool {
+	return w.hijack != nil && !w.hijacked
+}
+
+// Hijack hands the connection to the caller, who becomes responsible for
+// closing it. Whatever was written so far, such as a 101 response, is
+// flushed first; after that the Writer can no longer be used.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.hijack == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	if err := w.Flush(); err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	conn, rd := w.hijack()
+	return conn, bufio.NewReadWriter(bufio.NewReader(rd), w.buff), nil
+}
+
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+// Flush sends everything written so far to the client. A response whose
+// length is not known yet is switched to chunked encoding.
+func (w *Writer) Flush() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if w.state >= stateHeadersWritten && !w.committed {
+		if err := w.commit(false); err != nil {
+			return err
+		}
+	}
+	return w.flush()
+}
+
+// Finish completes the response: it computes Content-Length for bodies
+// that were never flushed, terminates chunked bodies and flushes. A
+// handler that wrote nothing gets an empty 200 response.
+func (w *Writer) Finish() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if w.state == stateInitial {
+		if err := w.WriteStatusLine(StatusCodeOk); err != nil {
+			return w.fail(err)
+		}
+	}
+	if w.state == stateStatusWritten {
+		if err := w.WriteHeaders(headers.Headers{}); err != nil {
+			return w.fail(err)
+		}
+	}
+	if !w.committed {
+		if err := w.commit(true); err != nil {
+			return err
+		}
+	}
+	if w.framing == framingLength && !w.head && w.written != w.contentLength {
+		return w.fail(ErrContentLength)
+	}
+	if w.stream != nil {
+		if w.state < stateTrailersWritten {
+			w.state = stateTrailersWritten
+			if err := w.stream.Close(nil); err != nil {
+				return w.fail(err)
+			}
+		}
+		return w.flush()
+	}
+	if w.framing == framingChunked && !w.head {
+		if w.state < stateBodyWritten {
+			if _, err := w.WriteChunkedBodyDone(); err != nil {
+				return w.fail(err)
+			}
+		}
+		if w.state < stateTrailersWritten {
+			if _, err := io.WriteString(w.buff, "\r\n"); err != nil {
+				return w.fail(err)
+			}
+			w.state = stateTrailersWritten
+		}
+	}
+	return w.flush()
+}
+
+func (w *Writer) flush() error {
+	if w.stream != nil {
+		return w.stream.Flush()
+	}
+	return w.buff.Flush()
+}
+
+func (w *Writer) Committed() bool {
+	return w.committed
+}
+
+// Started reports whether a status line has been written, even if the
+// response is still buffered.
+func (w *Writer) Started() bool {
+	return w.state != stateInitial
+}
+
+// Reset discards a response that has not been committed yet so that a
+// different one can be written in its place.
+func (w *Writer) Reset() error {
+	if w.committed {
+		return errors.New("error: cannot reset a committed response")
+	}
+	closeConn := w.closeConn
+	*w = Writer{
+		state:     stateInitial,
+		buff:      w.buff,
+		stream:    w.stream,
+		headers:   make(headers.Headers),
+		closeConn: closeConn,
+		http10:    w.http10,
+		head:      w.head,
+		hijack:    w.hijack,
+		onHeaders: w.onHeaders,
+	}
+	return nil
+}
+
+func (w *Writer) Header() headers.Headers {
+	return w.headers
+}
+
+// SetCookie adds a Set-Cookie header; each cookie is written on its own line.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.committed {
+		return errors.New("error: cannot set cookie after headers were sent")
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.headers.Add("Set-Cookie", c.String())
+	return nil
+}
+
+func (w *Writer) OnHeaders(fn func(h headers.Headers)) {
+	w.onHeaders = append(w.onHeaders, fn)
+}
+
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+func (w *Writer) SetConnectionClose() {
+	w.closeConn = true
+}
+
+func (w *Writer) ConnectionClose() bool {
+	if w.closeConn || w.hijacked || w.state == stateInitial || w.err != nil {
+		return true
+	}
+	return w.headers.HasToken("Connection", "close")
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineWithReason(statusCode, StatusText(statusCode))
+}
+
+func (w *Writer) WriteStatusLineWithReason(statusCode StatusCode, reason string) error {
+	if w.hijacked {
+		return ErrHijacked
+	}
 	if w.state != stateInitial {
 		return fmt.Errorf("error: cannot write status line already in state %d", w.state)
 	}
-	statusMessage := getStatusMessage(statusCode)
-	fmt.Fprintf(&w.buff, "HTTP/1.1 %d %s\r\n", statusCode, statusMessage)
+	if !statusCode.Valid() {
+		return ErrInvalidStatusCode
+	}
+	if !validReasonPhrase(reason) {
+		return ErrInvalidReasonPhrase
+	}
+	w.statusCode = statusCode
+	w.reason = reason
 	w.state = stateStatusWritten
 	return nil
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+func (w *Writer) WriteHeaders(h headers.Headers) error {
+	if w.hijacked {
+		return ErrHijacked
+	}
 	if w.state != stateStatusWritten {
 		return fmt.Errorf("error: cannot write headers already in state %d", w.state)
 	}
-	w.headers = headers
+	mergeHeaders(w.headers, h)
+	contentLength, hasLength := w.headers.Get("Content-Length"), w.headers.Has("Content-Length")
+	transferEncoding, hasEncoding := w.headers.Get("Transfer-Encoding"), w.headers.Has("Transfer-Encoding")
+	switch {
+	case hasLength && hasEncoding:
+		return ErrInconsistentFraming
+	case hasEncoding:
+		if !strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked") {
+			return fmt.Errorf("%w: unsupported Transfer-Encoding %q", ErrInconsistentFraming, transferEncoding)
+		}
+		w.framing = framingChunked
+	case hasLength:
+		n, ok := headers.ParseContentLength(contentLength)
+		if !ok {
+			return fmt.Errorf("%w: invalid Content-Length %q", ErrInconsistentFraming, contentLength)
+		}
+		w.contentLength = n
+		w.framing = framingLength
+	}
+	if !w.bodyAllowed() {
+		if w.framing == framingChunked {
+			return ErrBodyNotAllowed
+		}
+		w.framing = framingNone
+	}
 	w.state = stateHeadersWritten
-	return writeHeaders(&w.buff, headers)
+	return nil
 }
 
 func (w *Writer) WriteBody(p []byte) (int, error) {
-	if w.state != stateHeadersWritten {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if w.state != stateHeadersWritten && w.state != stateWritingBody {
 		return 0, fmt.Errorf("error: cannot write body already in state %d", w.state)
 	}
-	n, err := w.buff.Write(p)
-	if err != nil {
-		return 0, err
+	if w.framing == framingNone {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, ErrBodyNotAllowed
 	}
-	w.state = stateBodyWritten
-	return n, nil
+	w.state = stateWritingBody
+	if w.head {
+		w.written += int64(len(p))
+		return len(p), nil
+	}
+	if !w.committed {
+		if w.framing == framingUnknown && len(w.body)+len(p) <= bodyBufferSize {
+			w.body = append(w.body, p...)
+			return len(p), nil
+		}
+		if err := w.commit(false); err != nil {
+			return 0, err
+		}
+	}
+	return w.writeFramed(p)
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	if w.state != stateHeadersWritten {
-		return 0, fmt.Errorf("error: cannot write body already in state %d", w.state)
+	if err := w.useChunked(); err != nil {
+		return 0, err
 	}
-	hexLen := strconv.FormatInt(int64(len(p)), 16)
-	chunk := []byte(hexLen + "\r\n")
-	chunk = append(chunk, p...)
-	chunk = append(chunk, []byte("\r\n")...)
-	return w.buff.Write(chunk)
+	return w.WriteBody(p)
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if w.state != stateHeadersWritten && w.state != stateWritingBody {
+		return 0, fmt.Errorf("error: cannot finish body already in state %d", w.state)
+	}
+	if err := w.useChunked(); err != nil {
+		return 0, err
+	}
+	if !w.committed {
+		if err := w.commit(false); err != nil {
+			return 0, err
+		}
+	}
 	w.state = stateBodyWritten
-	return w.buff.Write([]byte("0\r\n\r\n"))
+	if w.stream != nil || w.framing == framingClose || w.head {
+		return 0, nil
+	}
+	return io.WriteString(w.buff, "0\r\n")
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if w.state == stateHeadersWritten || w.state == stateWritingBody {
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+	}
 	if w.state != stateBodyWritten {
 		return fmt.Errorf("error: cannot write trailers already in state %d", w.state)
 	}
-	maps.Copy(w.headers, h)
-	return writeHeaders(&w.buff, h)
+	mergeHeaders(w.headers, h)
+	w.state = stateTrailersWritten
+	if w.stream != nil {
+		return w.stream.Close(h)
+	}
+	if w.framing == framingClose || w.head {
+		return nil
+	}
+	return writeHeaders(w.buff, h)
 }
 
-func getStatusMessage(statusCode StatusCode) string {
-	reason := ""
-	switch statusCode {
-	case StatusCodeOk:
-		reason = "OK"
-	case StatusCodeBadRequest:
-		reason = "Bad Request"
-	case StatusCodeInternalError:
-		reason = "Internal Server Error"
+func (w *Writer) useChunked() error {
+	switch w.framing {
+	case framingChunked, framingClose:
+		return nil
+	case framingUnknown:
+		if w.committed {
+			return ErrInconsistentFraming
+		}
+		w.framing = framingChunked
+		return nil
+	case framingNone:
+		return ErrBodyNotAllowed
+	default:
+		return ErrInconsistentFraming
 	}
-	return reason
+}
+
+// commit writes the status line and headers, settling on a framing if the
+// handler didn't choose one. final reports whether the body is complete.
+func (w *Writer) commit(final bool) error {
+	if w.headers.Has("Trailer") && w.framing == framingUnknown {
+		w.framing = framingChunked
+	}
+	if w.framing == framingUnknown {
+		if final {
+			w.framing = framingLength
+			w.contentLength = int64(len(w.body))
+			if w.head {
+				w.contentLength = w.written
+			}
+		} else {
+			w.framing = framingChunked
+		}
+	}
+	if w.framing == framingChunked && w.http10 && w.stream == nil {
+		w.framing = framingClose
+		w.closeConn = true
+		w.headers.Del("Transfer-Encoding")
+		w.headers.Del("Trailer")
+	}
+	switch w.framing {
+	case framingLength:
+		if !w.headers.Has("Content-Length") {
+			w.headers.Set("Content-Length", strconv.FormatInt(w.contentLength, 10))
+		}
+	case framingChunked:
+		if !w.headers.Has("Transfer-Encoding") && w.stream == nil {
+			w.headers.Set("Transfer-Encoding", "chunked")
+		}
+	}
+	for _, fn := range w.onHeaders {
+		fn(w.headers)
+	}
+	// A 101 response carries "Connection: Upgrade" and the connection
+	// changes hands, so keep-alive bookkeeping doesn't apply to it.
+	switch {
+	case w.statusCode == StatusCodeSwitchingProtocols:
+	case w.closeConn:
+		w.headers.Set("Connection", "close")
+	case w.http10 && !w.headers.HasToken("Connection", "close"):
+		w.headers.Set("Connection", "keep-alive")
+	}
+	w.committed = true
+	if err := w.writeHead(); err != nil {
+		return w.fail(err)
+	}
+	body := w.body
+	w.body = nil
+	if _, err := w.writeFramed(body); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (w *Writer) writeHead() error {
+	if w.stream != nil {
+		return w.stream.WriteHeaders(w.statusCode, w.headers)
+	}
+	if _, err := fmt.Fprintf(w.buff, "HTTP/1.1 %d %s\r\n", w.statusCode, w.reason); err != nil {
+		return err
+	}
+	return writeHeaders(w.buff, w.headers)
+}
+
+func (w *Writer) writeFramed(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.head {
+		w.written += int64(len(p))
+		return len(p), nil
+	}
+	if w.framing == framingLength && w.written+int64(len(p)) > w.contentLength {
+		return 0, w.fail(ErrContentLength)
+	}
+	if w.stream != nil && w.framing != framingNone {
+		n, err := w.stream.Write(p)
+		w.written += int64(n)
+		if err != nil {
+			return n, w.fail(err)
+		}
+		return n, nil
+	}
+	switch w.framing {
+	case framingLength, framingClose:
+		n, err := w.buff.Write(p)
+		w.written += int64(n)
+		if err != nil {
+			return n, w.fail(err)
+		}
+		return n, nil
+	case framingChunked:
+		if _, err := fmt.Fprintf(w.buff, "%x\r\n", len(p)); err != nil {
+			return 0, w.fail(err)
+		}
+		n, err := w.buff.Write(p)
+		w.written += int64(n)
+		if err != nil {
+			return n, w.fail(err)
+		}
+		if _, err := io.WriteString(w.buff, "\r\n"); err != nil {
+			return n, w.fail(err)
+		}
+		return n, nil
+	default:
+		return 0, ErrBodyNotAllowed
+	}
+}
+
+func (w *Writer) bodyAllowed() bool {
+	return w.statusCode >= 200 && w.statusCode != StatusCodeNoContent && w.statusCode != StatusCodeNotModified
+}
+
+func (w *Writer) fail(err error) error {
+	if w.err == nil {
+		w.err = err
+	}
+	return err
 }
 
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	h := headers.Headers{}
-	h["Content-Length"] = fmt.Sprint(contentLen)
-	h["Connection"] = "close"
-	h["Content-Type"] = "text/plain"
+	h.Set("Content-Length", fmt.Sprint(contentLen))
+	h.Set("Content-Type", "text/plain")
 	return h
 }
 
-func writeHeaders(w io.Writer, headers headers.Headers) error {
-	for key, val := range headers {
-		_, err := fmt.Fprintf(w, "%s: %s\r\n", key, val)
-		if err != nil {
-			return err
+// WriteStatus writes a complete plain-text response whose body is just the
+// status text, for errors and other responses with nothing more to say.
+func WriteStatus(w *Writer, statusCode StatusCode) error {
+	body := []byte(StatusText(statusCode) + "\n")
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(GetDefaultHeaders(len(body))); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(body)
+	return err
+}
+
+func mergeHeaders(dst, src headers.Headers) {
+	for key, vals := range src {
+		dst[headers.CanonicalKey(key)] = vals
+	}
+}
+
+// writeHeaders serializes fields sorted by canonical name so that the same
+// headers always produce the same bytes on the wire.
+func writeHeaders(w io.Writer, h headers.Headers) error {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return strings.Compare(headers.CanonicalKey(a), headers.CanonicalKey(b))
+	})
+	for _, key := range keys {
+		vals := h[key]
+		if len(vals) == 0 {
+			continue
+		}
+		if headers.Combinable(key) {
+			vals = []string{strings.Join(vals, ", ")}
+		}
+		for _, val := range vals {
+			_, err := fmt.Fprintf(w, "%s: %s\r\n", headers.CanonicalKey(key), val)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	_, err := fmt.Fprint(w, "\r\n")
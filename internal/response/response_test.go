@@ -0,0 +1,382 @@
+package response_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Jud1k/web_server/internal/cookie"
+	"github.com/Jud1k/web_server/internal/headers"
+	"github.com/Jud1k/web_server/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStatusLineKnownCodes(t *testing.T) {
+	tests := map[response.StatusCode]string{
+		response.StatusCodeOk:                 "HTTP/1.1 200 OK\r\n",
+		response.StatusCodeNoContent:          "HTTP/1.1 204 No Content\r\n",
+		response.StatusCodePermanentRedirect:  "HTTP/1.1 308 Permanent Redirect\r\n",
+		response.StatusCodeTooManyRequests:    "HTTP/1.1 429 Too Many Requests\r\n",
+		response.StatusCodeServiceUnavailable: "HTTP/1.1 503 Service Unavailable\r\n",
+	}
+	for code, want := range tests {
+		var buf bytes.Buffer
+		w := response.NewWriter(&buf)
+		require.NoError(t, w.WriteStatusLine(code))
+		require.NoError(t, w.Finish())
+		assert.True(t, strings.HasPrefix(buf.String(), want))
+	}
+}
+
+func TestWriteStatusLineUnknownCode(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(299))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 299 \r\n"))
+}
+
+func TestWriteStatusLineCustomReason(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLineWithReason(response.StatusCodeOk, "Totally Fine"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 Totally Fine\r\n"))
+}
+
+func TestWriteStatusLineRejectsInvalid(t *testing.T) {
+	w := response.NewWriter(&bytes.Buffer{})
+	assert.ErrorIs(t, w.WriteStatusLine(99), response.ErrInvalidStatusCode)
+	assert.ErrorIs(t, w.WriteStatusLine(1000), response.ErrInvalidStatusCode)
+	assert.ErrorIs(t, w.WriteStatusLineWithReason(200, "OK\r\nX-Injected: 1"), response.ErrInvalidReasonPhrase)
+}
+
+func textHeaders() headers.Headers {
+	h := headers.Headers{}
+	h.Set("Content-Type", "text/plain")
+	return h
+}
+
+func TestFinishSetsContentLength(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(textHeaders()))
+	_, err := w.WriteBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	resp := buf.String()
+	assert.Contains(t, resp, "Content-Length: 11\r\n")
+	assert.NotContains(t, resp, "Transfer-Encoding")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhello world"))
+}
+
+func TestFlushSwitchesToChunked(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(textHeaders()))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	_, err = w.WriteBody([]byte("world!"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	resp := buf.String()
+	assert.Contains(t, resp, "Transfer-Encoding: chunked\r\n")
+	assert.NotContains(t, resp, "Content-Length")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n5\r\nhello\r\n6\r\nworld!\r\n0\r\n\r\n"))
+}
+
+func TestLargeBodySwitchesToChunked(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(textHeaders()))
+	_, err := w.WriteBody(bytes.Repeat([]byte("a"), 8192))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Transfer-Encoding: chunked\r\n")
+	assert.Contains(t, buf.String(), "\r\n2000\r\n")
+}
+
+func TestTrailersAfterChunkedBody(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	h := textHeaders()
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	trailers := headers.Headers{}
+	trailers.Set("X-Checksum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish())
+
+	resp := buf.String()
+	assert.Contains(t, resp, "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n"))
+}
+
+func TestRejectsInconsistentFraming(t *testing.T) {
+	w := response.NewWriter(&bytes.Buffer{})
+	h := textHeaders()
+	h.Set("Content-Length", "5")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	assert.ErrorIs(t, w.WriteHeaders(h), response.ErrInconsistentFraming)
+}
+
+func TestRejectsMalformedContentLength(t *testing.T) {
+	for _, value := range []string{"+5", "-5", " 5", "5, 5", "0x5"} {
+		t.Run(value, func(t *testing.T) {
+			w := response.NewWriter(&bytes.Buffer{})
+			h := textHeaders()
+			h.Set("Content-Length", value)
+			require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+			assert.ErrorIs(t, w.WriteHeaders(h), response.ErrInconsistentFraming)
+		})
+	}
+}
+
+func TestRejectsChunkedBodyWithContentLength(t *testing.T) {
+	w := response.NewWriter(&bytes.Buffer{})
+	h := textHeaders()
+	h.Set("Content-Length", "5")
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	assert.ErrorIs(t, err, response.ErrInconsistentFraming)
+}
+
+func TestRejectsBodyLongerThanContentLength(t *testing.T) {
+	w := response.NewWriter(&bytes.Buffer{})
+	h := textHeaders()
+	h.Set("Content-Length", "3")
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody([]byte("hello"))
+	assert.ErrorIs(t, err, response.ErrContentLength)
+	assert.True(t, w.ConnectionClose())
+}
+
+func TestRejectsShortBodyOnFinish(t *testing.T) {
+	w := response.NewWriter(&bytes.Buffer{})
+	h := textHeaders()
+	h.Set("Content-Length", "10")
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.Finish(), response.ErrContentLength)
+}
+
+func TestNoContentHasNoBody(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeNoContent))
+	require.NoError(t, w.WriteHeaders(headers.Headers{}))
+	_, err := w.WriteBody([]byte("hello"))
+	assert.ErrorIs(t, err, response.ErrBodyNotAllowed)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", buf.String())
+}
+
+func TestSetCookieWrittenOnSeparateLines(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	h := headers.Headers{}
+	h.Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	h.Add("Set-Cookie", "b=2")
+	h.Add("Vary", "Accept")
+	h.Add("Vary", "Accept-Encoding")
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.Finish())
+
+	resp := buf.String()
+	assert.Contains(t, resp, "Set-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\nSet-Cookie: b=2\r\n")
+	assert.Contains(t, resp, "Vary: Accept, Accept-Encoding\r\n")
+}
+
+func TestHeadersWrittenInSortedOrder(t *testing.T) {
+	render := func() string {
+		var buf bytes.Buffer
+		w := response.NewWriter(&buf)
+		h := headers.Headers{}
+		h.Set("X-Zeta", "1")
+		h.Set("Content-Type", "text/plain")
+		h.Set("Cache-Control", "no-store")
+		h.Set("Trailer", "X-Checksum")
+		h.Set("Date", "Sun, 18 Oct 2026 10:00:00 GMT")
+		require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+		require.NoError(t, w.WriteHeaders(h))
+		_, err := w.WriteBody([]byte("hi"))
+		require.NoError(t, err)
+		trailers := headers.Headers{}
+		trailers.Set("X-Checksum", "abc")
+		trailers.Set("X-Alpha", "1")
+		require.NoError(t, w.WriteTrailers(trailers))
+		require.NoError(t, w.Finish())
+		return buf.String()
+	}
+	want := "HTTP/1.1 200 OK\r\n" +
+		"Cache-Control: no-store\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Date: Sun, 18 Oct 2026 10:00:00 GMT\r\n" +
+		"Trailer: X-Checksum\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"X-Zeta: 1\r\n" +
+		"\r\n" +
+		"2\r\nhi\r\n0\r\n" +
+		"X-Alpha: 1\r\n" +
+		"X-Checksum: abc\r\n" +
+		"\r\n"
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, want, render())
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", HttpOnly: true}))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", Secure: true, SameSite: cookie.SameSiteStrict}))
+	assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "bad name"}))
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(headers.Headers{}))
+	require.NoError(t, w.Finish())
+
+	out := buf.String()
+	assert.Contains(t, out, "Set-Cookie: a=1; HttpOnly\r\nSet-Cookie: b=2; Secure; SameSite=Strict\r\n")
+	assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "c", Value: "3"}))
+}
+
+func TestHTTP10StreamedBodyIsCloseDelimited(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetHTTP10()
+	h := textHeaders()
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	_, err = w.WriteBody([]byte("world!"))
+	require.NoError(t, err)
+	trailers := headers.Headers{}
+	trailers.Set("X-Checksum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish())
+
+	resp := buf.String()
+	assert.NotContains(t, resp, "Transfer-Encoding")
+	assert.NotContains(t, resp, "Trailer")
+	assert.NotContains(t, resp, "Content-Length")
+	assert.Contains(t, resp, "Connection: close\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhelloworld!"))
+	assert.True(t, w.ConnectionClose())
+}
+
+func TestHTTP10KeepAlive(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetHTTP10()
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(textHeaders()))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	resp := buf.String()
+	assert.Contains(t, resp, "Connection: keep-alive\r\n")
+	assert.Contains(t, resp, "Content-Length: 5\r\n")
+	assert.False(t, w.ConnectionClose())
+}
+
+func TestHijack(t *testing.T) {
+	w := response.NewWriter(&bytes.Buffer{})
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, response.ErrNotHijackable)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	w = response.NewWriter(server)
+	w.SetHijacker(func() (net.Conn, io.Reader) {
+		return server, strings.NewReader("buffered")
+	})
+	require.NoError(t, w.WriteStatusLine(response.StatusCodeSwitchingProtocols))
+	h := headers.Headers{}
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", "test")
+	require.NoError(t, w.WriteHeaders(h))
+	w.SetConnectionClose()
+
+	head := make(chan string)
+	go func() {
+		buf := make([]byte, 512)
+		n, _ := client.Read(buf)
+		head <- string(buf[:n])
+	}()
+	conn, rw, err := w.Hijack()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n", <-head)
+
+	rest, err := io.ReadAll(rw.Reader)
+	require.NoError(t, err)
+	assert.Equal(t, "buffered", string(rest))
+	assert.True(t, w.Hijacked())
+	assert.ErrorIs(t, w.Finish(), response.ErrHijacked)
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, response.ErrHijacked)
+}
+
+func TestFinishWithoutResponseSendsEmptyOK(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", buf.String())
+	assert.False(t, w.ConnectionClose())
+}
+
+func TestHEADSuppressesBody(t *testing.T) {
+	explicit := headers.Headers{}
+	explicit.Set("Content-Length", "42")
+	tests := []struct {
+		name   string
+		h      headers.Headers
+		body   []byte
+		length string
+	}{
+		{"buffered body", headers.Headers{}, []byte("hello"), "5"},
+		{"large body", headers.Headers{}, bytes.Repeat([]byte("a"), 8192), "8192"},
+		{"explicit length without body", explicit, nil, "42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := response.NewWriter(&buf)
+			w.SetHEAD()
+			require.NoError(t, w.WriteStatusLine(response.StatusCodeOk))
+			require.NoError(t, w.WriteHeaders(tt.h))
+			_, err := w.WriteBody(tt.body)
+			require.NoError(t, err)
+			require.NoError(t, w.Finish())
+			resp := buf.String()
+			assert.Contains(t, resp, "Content-Length: "+tt.length+"\r\n")
+			assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+			assert.NotContains(t, resp, "Transfer-Encoding")
+			assert.False(t, w.ConnectionClose())
+		})
+	}
+}
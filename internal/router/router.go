@@ -0,0 +1,277 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Jud1k/web_server/internal/request"
+	"github.com/Jud1k/web_server/internal/response"
+	"github.com/Jud1k/web_server/internal/server"
+)
+
+type segmentKind int
+
+// Kinds are ordered by precedence: when several routes match a path,
+// literal segments win over parameters and parameters over wildcards.
+const (
+	segmentLiteral segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  server.Handler
+}
+
+type Router struct {
+	routes           []*route
+	middlewares      []server.Middleware
+	NotFound         server.Handler
+	MethodNotAllowed server.Handler
+}
+
+type Group struct {
+	router      *Router
+	prefix      string
+	middlewares []server.Middleware
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+func (r *Router) Handle(method, pattern string, handler server.Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	for _, rt := range r.routes {
+		if rt.method == method && rt.pattern == pattern {
+			panic(fmt.Sprintf("router: duplicate route %s %s", method, pattern))
+		}
+	}
+	r.routes = append(r.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		handler:  handler,
+	})
+}
+
+func (r *Router) Use(middlewares ...server.Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+func (r *Router) Get(pattern string, handler server.Handler) {
+	r.Handle("GET", pattern, handler)
+}
+
+func (r *Router) Post(pattern string, handler server.Handler) {
+	r.Handle("POST", pattern, handler)
+}
+
+func (r *Router) Put(pattern string, handler server.Handler) {
+	r.Handle("PUT", pattern, handler)
+}
+
+func (r *Router) Patch(pattern string, handler server.Handler) {
+	r.Handle("PATCH", pattern, handler)
+}
+
+func (r *Router) Delete(pattern string, handler server.Handler) {
+	r.Handle("DELETE", pattern, handler)
+}
+
+func (r *Router) Group(prefix string) *Group {
+	return &Group{router: r, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+func (g *Group) Use(middlewares ...server.Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+func (g *Group) Handle(method, pattern string, handler server.Handler) {
+	g.router.Handle(method, g.prefix+pattern, server.Chain(g.middlewares...)(handler))
+}
+
+func (g *Group) Get(pattern string, handler server.Handler) {
+	g.Handle("GET", pattern, handler)
+}
+
+func (g *Group) Post(pattern string, handler server.Handler) {
+	g.Handle("POST", pattern, handler)
+}
+
+func (g *Group) Put(pattern string, handler server.Handler) {
+	g.Handle("PUT", pattern, handler)
+}
+
+func (g *Group) Patch(pattern string, handler server.Handler) {
+	g.Handle("PATCH", pattern, handler)
+}
+
+func (g *Group) Delete(pattern string, handler server.Handler) {
+	g.Handle("DELETE", pattern, handler)
+}
+
+func (g *Group) Group(prefix string) *Group {
+	return &Group{
+		router:      g.router,
+		prefix:      g.prefix + strings.TrimSuffix(prefix, "/"),
+		middlewares: slices.Clone(g.middlewares),
+	}
+}
+
+func (r *Router) Serve(w *response.Writer, req *request.Request) {
+	server.Chain(r.middlewares...)(r.dispatch)(w, req)
+}
+
+func (r *Router) dispatch(w *response.Writer, req *request.Request) {
+	pathSegments := splitPath(req.RawPath())
+	best, bestValues, allowed := r.lookup(req.RequestLine.Method, pathSegments)
+	if best == nil && req.RequestLine.Method == "HEAD" {
+		// HEAD falls back to the GET handler; the writer drops the body.
+		best, bestValues, _ = r.lookup("GET", pathSegments)
+	}
+	if best != nil {
+		for name, value := range bestValues {
+			req.SetPathValue(name, value)
+		}
+		best.handler(w, req)
+		return
+	}
+	if len(allowed) > 0 {
+		if slices.Contains(allowed, "GET") && !slices.Contains(allowed, "HEAD") {
+			allowed = append(allowed, "HEAD")
+		}
+		slices.Sort(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		if r.MethodNotAllowed != nil {
+			r.MethodNotAllowed(w, req)
+			return
+		}
+		response.WriteStatus(w, response.StatusCodeMethodNotAllowed)
+		return
+	}
+	if r.NotFound != nil {
+		r.NotFound(w, req)
+		return
+	}
+	response.WriteStatus(w, response.StatusCodeNotFound)
+}
+
+// lookup returns the most specific route for method and the path, along
+// with the methods of the other routes that match the path.
+func (r *Router) lookup(method string, pathSegments []string) (*route, map[string]string, []string) {
+	var best *route
+	var bestValues map[string]string
+	var allowed []string
+	for _, rt := range r.routes {
+		values, ok := rt.match(pathSegments)
+		if !ok {
+			continue
+		}
+		if rt.method != method {
+			if !slices.Contains(allowed, rt.method) {
+				allowed = append(allowed, rt.method)
+			}
+			continue
+		}
+		if best == nil || rt.moreSpecific(best) {
+			best = rt
+			bestValues = values
+		}
+	}
+	return best, bestValues, allowed
+}
+
+func (rt *route) match(pathSegments []string) (map[string]string, bool) {
+	values := map[string]string{}
+	for i, seg := range rt.segments {
+		if seg.kind == segmentWildcard {
+			values[seg.value] = strings.Join(pathSegments[i:], "/")
+			return values, true
+		}
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentLiteral:
+			if seg.value != pathSegments[i] {
+				return nil, false
+			}
+		case segmentParam:
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			values[seg.value] = pathSegments[i]
+		}
+	}
+	if len(rt.segments) != len(pathSegments) {
+		return nil, false
+	}
+	return values, true
+}
+
+func (rt *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(rt.segments) && i < len(other.segments); i++ {
+		if rt.segments[i].kind != other.segments[i].kind {
+			return rt.segments[i].kind < other.segments[i].kind
+		}
+	}
+	return len(rt.segments) > len(other.segments)
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("router: pattern %q must start with /", pattern)
+	}
+	parts := splitPath(pattern)
+	segments := make([]segment, 0, len(parts))
+	for i, part := range parts {
+		last := i == len(parts)-1
+		switch {
+		case part == "*":
+			if !last {
+				return nil, fmt.Errorf("router: wildcard must be the last segment in %q", pattern)
+			}
+			segments = append(segments, segment{kind: segmentWildcard, value: "*"})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "...}"):
+			if !last {
+				return nil, fmt.Errorf("router: wildcard must be the last segment in %q", pattern)
+			}
+			segments = append(segments, segment{kind: segmentWildcard, value: part[1 : len(part)-4]})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			if name == "" {
+				return nil, fmt.Errorf("router: empty parameter name in %q", pattern)
+			}
+			segments = append(segments, segment{kind: segmentParam, value: name})
+		default:
+			segments = append(segments, segment{kind: segmentLiteral, value: part})
+		}
+	}
+	return segments, nil
+}
+
+// splitPath splits the escaped path before decoding each segment, so an
+// encoded slash stays inside a single segment.
+func splitPath(path string) []string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, seg := range segments {
+		if decoded, err := url.PathUnescape(seg); err == nil {
+			segments[i] = decoded
+		}
+	}
+	return segments
+}
@@ -0,0 +1,137 @@
+package router_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Jud1k/web_server/internal/request"
+	"github.com/Jud1k/web_server/internal/response"
+	"github.com/Jud1k/web_server/internal/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, r *router.Router, method, target string) string {
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	r.Serve(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func reply(body string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		data := []byte(body)
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(response.GetDefaultHeaders(len(data)))
+		w.WriteBody(data)
+	}
+}
+
+func TestRouterLiteralMatch(t *testing.T) {
+	r := router.New()
+	r.Get("/", reply("root"))
+	r.Get("/html-ok", reply("ok"))
+
+	assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/"), "root"))
+	assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/html-ok"), "ok"))
+}
+
+func TestRouterPathParams(t *testing.T) {
+	r := router.New()
+	r.Get("/users/{id}/posts/{post}", func(w *response.Writer, req *request.Request) {
+		reply(req.PathValue("id")+":"+req.PathValue("post"))(w, req)
+	})
+
+	assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/users/42/posts/7?page=2"), "42:7"))
+	assert.Contains(t, serve(t, r, "GET", "/users//posts/7"), "404 Not Found")
+}
+
+func TestRouterWildcard(t *testing.T) {
+	r := router.New()
+	r.Get("/static/{file...}", func(w *response.Writer, req *request.Request) {
+		reply(req.PathValue("file"))(w, req)
+	})
+	r.Get("/files/*", func(w *response.Writer, req *request.Request) {
+		reply(req.PathValue("*"))(w, req)
+	})
+
+	assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/static/css/site.css"), "css/site.css"))
+	assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/files/a/b"), "a/b"))
+}
+
+func TestRouterPrecedence(t *testing.T) {
+	r := router.New()
+	r.Get("/users/{id}", reply("param"))
+	r.Get("/users/me", reply("literal"))
+	r.Get("/users/{rest...}", reply("wildcard"))
+
+	assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/users/me"), "literal"))
+	assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/users/42"), "param"))
+	assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/users/42/avatar"), "wildcard"))
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	r := router.New()
+	r.Get("/items", reply("list"))
+	r.Post("/items", reply("create"))
+
+	resp := serve(t, r, "DELETE", "/items")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, resp, "Allow: GET, HEAD, POST\r\n")
+}
+
+func TestRouterHEADFallsBackToGET(t *testing.T) {
+	r := router.New()
+	r.Get("/items", reply("list"))
+	r.Get("/users/{id}", func(w *response.Writer, req *request.Request) {
+		reply(req.PathValue("id"))(w, req)
+	})
+	r.Get("/explicit", reply("get"))
+	r.Handle("HEAD", "/explicit", reply("head"))
+
+	assert.True(t, strings.HasPrefix(serve(t, r, "HEAD", "/items"), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(serve(t, r, "HEAD", "/users/42"), "42"))
+	assert.True(t, strings.HasSuffix(serve(t, r, "HEAD", "/explicit"), "head"))
+
+	resp := serve(t, r, "POST", "/items")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, resp, "Allow: GET, HEAD\r\n")
+}
+
+func TestRouterNotFound(t *testing.T) {
+	r := router.New()
+	r.Get("/items", reply("list"))
+
+	assert.True(t, strings.HasPrefix(serve(t, r, "GET", "/missing"), "HTTP/1.1 404 Not Found\r\n"))
+}
+
+func TestRouterGroups(t *testing.T) {
+	r := router.New()
+	api := r.Group("/api")
+	v1 := api.Group("/v1/")
+	v1.Get("/users/{id}", func(w *response.Writer, req *request.Request) {
+		reply("user "+req.PathValue("id"))(w, req)
+	})
+
+	assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/api/v1/users/9"), "user 9"))
+	assert.Contains(t, serve(t, r, "GET", "/v1/users/9"), "404 Not Found")
+}
+
+func TestRouterDuplicateRoutePanics(t *testing.T) {
+	r := router.New()
+	r.Get("/items", reply("list"))
+	assert.Panics(t, func() { r.Get("/items", reply("again")) })
+}
+
+func TestRouterDecodesParams(t *testing.T) {
+	r := router.New()
+	r.Get("/users/{id}", func(w *response.Writer, req *request.Request) {
+		reply(req.PathValue("id"))(w, req)
+	})
+
+	assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/users/a%2Fb%20c"), "a/b c"))
+}
@@ -1,56 +1,261 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/Jud1k/web_server/internal/certs"
+	"github.com/Jud1k/web_server/internal/http2"
+	"github.com/Jud1k/web_server/internal/netutil"
 	"github.com/Jud1k/web_server/internal/request"
 	"github.com/Jud1k/web_server/internal/response"
 )
 
+const (
+	shutdownPollInterval = 50 * time.Millisecond
+	lingerTimeout        = 500 * time.Millisecond
+	maxLingerBytes       = 256 << 10
+)
+
+var (
+	errReadTimeout = errors.New("error: timed out reading request")
+	errInternal    = errors.New("error: internal server error")
+)
+
+type connState int
+
+const (
+	connStateIdle connState = iota
+	connStateActive
+)
+
 type Server struct {
 	listener net.Listener
 	closed   atomic.Bool
 	handler  Handler
+	opts     Options
+	mu       sync.Mutex
+	conns    map[net.Conn]connState
+	h2conns  map[*http2.Conn]struct{}
+}
+
+type Options struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	Limits            request.Limits
+	ErrorRenderer     ErrorRenderer
+	// TLSConfig is the base configuration for ServeTLS. Certificates come
+	// from the certs.Store unless GetCertificate is already set.
+	TLSConfig  *tls.Config
+	ClientAuth certs.ClientAuth
+	ClientCAs  *x509.CertPool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		Limits:            request.DefaultLimits(),
+	}
+}
+
+func (o Options) idleTimeout() time.Duration {
+	if o.IdleTimeout != 0 {
+		return o.IdleTimeout
+	}
+	return o.ReadTimeout
+}
+
+func (o Options) readHeaderTimeout() time.Duration {
+	if o.ReadHeaderTimeout != 0 {
+		return o.ReadHeaderTimeout
+	}
+	return o.ReadTimeout
 }
 
 type Handler func(w *response.Writer, req *request.Request)
 
+type Middleware func(Handler) Handler
+
+// Chain composes middlewares so that the first one runs outermost.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(handler Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			handler = middlewares[i](handler)
+		}
+		return handler
+	}
+}
+
 type HandlerError struct {
-	statusCode response.StatusCode
-	message    string
+	StatusCode response.StatusCode
+	Err        error
+}
+
+func (e *HandlerError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+type ErrorRenderer func(w *response.Writer, hErr *HandlerError)
+
+func DefaultErrorRenderer(w *response.Writer, hErr *HandlerError) {
+	body := []byte(hErr.Error())
+	w.WriteStatusLine(hErr.StatusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithOptions(port, handler, DefaultOptions())
 }
 
-func (e *HandlerError) Write(w io.Writer) error {
-	_, err := fmt.Fprint(w, e)
+func ServeWithOptions(port int, handler Handler, opts Options) (*Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return newServer(listener, handler, opts), nil
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
+func ServeTLS(port int, handler Handler, store *certs.Store) (*Server, error) {
+	return ServeTLSWithOptions(port, handler, store, DefaultOptions())
+}
+
+func ServeTLSWithOptions(port int, handler Handler, store *certs.Store, opts Options) (*Server, error) {
+	config := &tls.Config{}
+	if opts.TLSConfig != nil {
+		config = opts.TLSConfig.Clone()
+	}
+	if config.GetCertificate == nil {
+		config.GetCertificate = store.GetCertificate
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{http2.NextProto, "http/1.1"}
+	}
+	if opts.ClientAuth != certs.ClientAuthNone {
+		if opts.ClientCAs == nil {
+			return nil, certs.ErrNoClientCAs
+		}
+		config.ClientAuth = opts.ClientAuth.TLSType()
+		config.ClientCAs = opts.ClientCAs
+	}
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
-	server := &Server{listener: listener, handler: handler}
+	return newServer(tls.NewListener(listener, config), handler, opts), nil
+}
+
+func newServer(listener net.Listener, handler Handler, opts Options) *Server {
+	server := &Server{
+		listener: listener,
+		handler:  handler,
+		opts:     opts,
+		conns:    make(map[net.Conn]connState),
+		h2conns:  make(map[*http2.Conn]struct{}),
+	}
 	go server.listen()
-	return server, nil
+	return server
+}
+
+// Addr returns the address the server is listening on, which is useful
+// after passing port 0.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
 }
 
 func (s *Server) Close() error {
 	s.closed.Store(true)
-	return s.listener.Close()
+	err := s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closed.Store(true)
+	err := s.listener.Close()
+	s.mu.Lock()
+	for h2 := range s.h2conns {
+		h2.Shutdown()
+	}
+	s.mu.Unlock()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if state == connStateIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) setConnState(conn net.Conn, state connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[conn]; ok {
+		s.conns[conn] = state
+	}
+}
+
+func (s *Server) trackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = connStateIdle
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
 }
 
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
 		if s.closed.Load() {
+			if conn != nil {
+				conn.Close()
+			}
 			return
 		}
 		if err != nil {
@@ -60,21 +265,208 @@ func (s *Server) listen() {
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
+		s.trackConn(conn)
 		go s.handle(conn)
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		hErr := &HandlerError{
-			statusCode: response.StatusCodeBadRequest,
-			message:    err.Error(),
+	defer s.untrackConn(conn)
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+		}
+	}()
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(netutil.Deadline(time.Now(), s.opts.readHeaderTimeout()))
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+		if state.NegotiatedProtocol == http2.NextProto {
+			s.serveHTTP2(conn, conn, tlsState)
+			return
+		}
+	}
+	reader := request.NewReaderWithLimits(conn, s.opts.Limits)
+	first := true
+	for {
+		if s.closed.Load() {
+			return
+		}
+		s.setConnState(conn, connStateIdle)
+		conn.SetReadDeadline(netutil.Deadline(time.Now(), s.opts.idleTimeout()))
+		if err := reader.Wait(); err != nil {
+			return
+		}
+		s.setConnState(conn, connStateActive)
+		start := time.Now()
+		conn.SetReadDeadline(netutil.Deadline(start, s.opts.readHeaderTimeout()))
+		if first && tlsState == nil {
+			first = false
+			h2c, err := reader.HasPrefix([]byte(http2.ClientPreface))
+			if err != nil {
+				return
+			}
+			if h2c {
+				s.serveHTTP2(conn, reader.Detach(), nil)
+				return
+			}
+		}
+		req, err := reader.ReadRequest()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return
+			}
+			s.writeError(conn, handlerErrorFrom(err))
+			return
+		}
+		req.TLS = tlsState
+		conn.SetReadDeadline(netutil.Deadline(start, s.opts.ReadTimeout))
+		conn.SetWriteDeadline(netutil.Deadline(time.Now(), s.opts.WriteTimeout))
+		writer := response.NewWriter(conn)
+		if !req.ProtoAtLeast(1, 1) {
+			writer.SetHTTP10()
+		}
+		if req.RequestLine.Method == "HEAD" {
+			writer.SetHEAD()
+		}
+		if req.WantsClose() || s.closed.Load() {
+			writer.SetConnectionClose()
+		}
+		writer.SetHijacker(func() (net.Conn, io.Reader) {
+			hijacked = true
+			s.untrackConn(conn)
+			conn.SetDeadline(time.Time{})
+			return conn, reader.Detach()
+		})
+		if !s.serve(writer, req) {
+			if hijacked {
+				conn.Close()
+				return
+			}
+			if !writer.Committed() {
+				s.writeError(conn, &HandlerError{
+					StatusCode: response.StatusCodeInternalError,
+					Err:        errInternal,
+				})
+			} else {
+				writer.Flush()
+			}
+			return
+		}
+		if hijacked {
+			return
+		}
+		if err := req.Body.Close(); err != nil {
+			// The handler's own response wins over the drain error; only
+			// a handler that wrote nothing gets the error page.
+			if !writer.Started() {
+				s.writeError(conn, handlerErrorFrom(err))
+				return
+			}
+			writer.SetConnectionClose()
+			if writer.Finish() == nil {
+				lingeringClose(conn)
+			}
+			return
+		}
+		if err := writer.Finish(); err != nil {
+			return
+		}
+		if writer.ConnectionClose() {
+			return
+		}
+	}
+}
+
+func (s *Server) serveHTTP2(conn net.Conn, rd io.Reader, tlsState *tls.ConnectionState) {
+	h2 := http2.NewConn(conn, rd, func(w *response.Writer, req *request.Request) {
+		defer req.RemoveFormFiles()
+		s.handler(w, req)
+	}, http2.Options{
+		Limits:       s.opts.Limits,
+		IdleTimeout:  s.opts.idleTimeout(),
+		WriteTimeout: s.opts.WriteTimeout,
+		TLS:          tlsState,
+	})
+	s.setConnState(conn, connStateActive)
+	s.mu.Lock()
+	s.h2conns[h2] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.h2conns, h2)
+		s.mu.Unlock()
+	}()
+	if s.closed.Load() {
+		h2.Shutdown()
+	}
+	h2.Serve()
+}
+
+func (s *Server) serve(w *response.Writer, req *request.Request) (ok bool) {
+	defer req.RemoveFormFiles()
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, rec, debug.Stack())
+			ok = false
 		}
-		hErr.Write(conn)
+	}()
+	s.handler(w, req)
+	return true
+}
+
+func (s *Server) writeError(conn net.Conn, hErr *HandlerError) {
+	conn.SetWriteDeadline(netutil.Deadline(time.Now(), s.opts.WriteTimeout))
+	writer := response.NewWriter(conn)
+	writer.SetConnectionClose()
+	render := s.opts.ErrorRenderer
+	if render == nil {
+		render = DefaultErrorRenderer
+	}
+	render(writer, hErr)
+	if writer.Finish() != nil {
+		return
+	}
+	lingeringClose(conn)
+}
+
+// lingeringClose keeps reading for a moment after the error response so
+// unread request bytes don't make the kernel reset the connection before
+// the client has seen the response.
+func lingeringClose(conn net.Conn) {
+	closer, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return
+	}
+	closer.CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.Copy(io.Discard, io.LimitReader(conn, maxLingerBytes))
+}
+
+func handlerErrorFrom(err error) *HandlerError {
+	hErr := &HandlerError{
+		StatusCode: response.StatusCodeBadRequest,
+		Err:        err,
+	}
+	switch {
+	case netutil.IsTimeout(err):
+		hErr.StatusCode = response.StatusCodeRequestTimeout
+		hErr.Err = errReadTimeout
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		hErr.StatusCode = response.StatusCodeURITooLong
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		hErr.StatusCode = response.StatusCodeRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		hErr.StatusCode = response.StatusCodeContentTooLarge
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		hErr.StatusCode = response.StatusCodeHTTPVersionNotSupported
+	case errors.Is(err, request.ErrUnsupportedTransferEncoding):
+		hErr.StatusCode = response.StatusCodeNotImplemented
 	}
-	writer := response.NewWriter()
-	s.handler(writer, req)
-	writer.WriteTo(conn)
+	return hErr
 }
@@ -0,0 +1,442 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jud1k/web_server/internal/certs"
+	"github.com/Jud1k/web_server/internal/headers"
+	"github.com/Jud1k/web_server/internal/netutil"
+	"github.com/Jud1k/web_server/internal/request"
+	"github.com/Jud1k/web_server/internal/response"
+	"github.com/Jud1k/web_server/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func text(body string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		h := headers.Headers{}
+		h.Set("Content-Type", "text/plain")
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+// dial opens a raw connection to srv that fails the test instead of
+// hanging if the server never answers.
+func dial(t *testing.T, srv *server.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func readResponse(t *testing.T, br *bufio.Reader) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp, string(body)
+}
+
+func baseURL(scheme string, srv *server.Server) string {
+	return fmt.Sprintf("%s://localhost:%d", scheme, srv.Addr().(*net.TCPAddr).Port)
+}
+
+// writeKeyPair writes a self-signed certificate for localhost and returns
+// it along with a pool that trusts it.
+func writeKeyPair(t *testing.T) (certs.KeyPair, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	pair := certs.KeyPair{
+		CertFile: filepath.Join(dir, "localhost.crt"),
+		KeyFile:  filepath.Join(dir, "localhost.key"),
+	}
+	require.NoError(t, os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pair, pool
+}
+
+func TestHTTP2OverTLSOutlivesHeaderTimeout(t *testing.T) {
+	pair, pool := writeKeyPair(t)
+	store, err := certs.NewStore(pair)
+	require.NoError(t, err)
+	opts := server.DefaultOptions()
+	opts.ReadHeaderTimeout = 100 * time.Millisecond
+	srv, err := server.ServeTLSWithOptions(0, text("ok"), store, opts)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	transport := &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	url := baseURL("https", srv) + "/"
+	for i := range 2 {
+		if i > 0 {
+			time.Sleep(3 * opts.ReadHeaderTimeout)
+		}
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, 2, resp.ProtoMajor)
+		assert.Equal(t, "ok", string(body))
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	srv, err := server.ServeWithOptions(0, text("ok"), server.DefaultOptions())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, br := dial(t, srv)
+	for range 3 {
+		_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		resp, body := readResponse(t, br)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.False(t, resp.Close)
+		assert.Equal(t, "ok", body)
+	}
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	resp, body := readResponse(t, br)
+	assert.True(t, resp.Close)
+	assert.Equal(t, "ok", body)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestIdleTimeoutClosesConnection(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.IdleTimeout = 100 * time.Millisecond
+	srv, err := server.ServeWithOptions(0, text("ok"), opts)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, br := dial(t, srv)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	readResponse(t, br)
+
+	start := time.Now()
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestReadHeaderTimeoutSends408(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.ReadHeaderTimeout = 100 * time.Millisecond
+	srv, err := server.ServeWithOptions(0, text("ok"), opts)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, br := dial(t, srv)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: loc")
+	require.NoError(t, err)
+	resp, _ := readResponse(t, br)
+	assert.Equal(t, 408, resp.StatusCode)
+	assert.True(t, resp.Close)
+}
+
+func TestReadTimeoutCoversBody(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.ReadTimeout = 100 * time.Millisecond
+	readErr := make(chan error, 1)
+	srv, err := server.ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		_, err := io.ReadAll(req.Body)
+		readErr <- err
+	}, opts)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, br := dial(t, srv)
+	_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nab")
+	require.NoError(t, err)
+	select {
+	case err := <-readErr:
+		assert.True(t, netutil.IsTimeout(err), "got %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("body read did not time out")
+	}
+	resp, _ := readResponse(t, br)
+	assert.Equal(t, 408, resp.StatusCode)
+	assert.True(t, resp.Close)
+}
+
+func TestWriteTimeoutAbortsSlowHandler(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.WriteTimeout = 100 * time.Millisecond
+	writeErr := make(chan error, 1)
+	srv, err := server.ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		time.Sleep(3 * opts.WriteTimeout)
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(response.GetDefaultHeaders(2))
+		w.WriteBody([]byte("ok"))
+		writeErr <- w.Flush()
+	}, opts)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, br := dial(t, srv)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	select {
+	case err := <-writeErr:
+		assert.True(t, netutil.IsTimeout(err), "got %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not finish")
+	}
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestShutdownDrainsActiveAndClosesIdle(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv, err := server.ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		text("ok")(w, req)
+	}, server.DefaultOptions())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	idle, idleBR := dial(t, srv)
+	_, err = io.WriteString(idle, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	readResponse(t, idleBR)
+
+	active, activeBR := dial(t, srv)
+	_, err = io.WriteString(active, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Shutdown(context.Background()) }()
+
+	_, err = idleBR.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v before the active request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	resp, body := readResponse(t, activeBR)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "ok", body)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not return after the active request finished")
+	}
+	_, err = net.Dial("tcp", srv.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestShutdownHonoursContext(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv, err := server.ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	}, server.DefaultOptions())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, br := dial(t, srv)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+	_, err = br.ReadByte()
+	assert.Error(t, err)
+}
+
+func TestHandlerPanicSends500(t *testing.T) {
+	srv, err := server.ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/panic" {
+			panic("boom")
+		}
+		text("ok")(w, req)
+	}, server.DefaultOptions())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, br := dial(t, srv)
+	_, err = io.WriteString(conn, "GET /panic HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, _ := readResponse(t, br)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.True(t, resp.Close)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	conn, br = dial(t, srv)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, body := readResponse(t, br)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "ok", body)
+}
+
+func TestUnreadLargeBodyKeepsResponse(t *testing.T) {
+	srv, err := server.ServeWithOptions(0, text("ok"), server.DefaultOptions())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, br := dial(t, srv)
+	size := 1 << 20
+	_, err = fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n", size)
+	require.NoError(t, err)
+	go conn.Write(make([]byte, size))
+	resp, body := readResponse(t, br)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.True(t, resp.Close)
+	assert.Equal(t, "ok", body)
+}
+
+// sendRaw writes raw to a fresh connection and returns the single response
+// the server answers with, checking that it then closes the connection.
+func sendRaw(t *testing.T, srv *server.Server, raw string) (*http.Response, string) {
+	t.Helper()
+	conn, br := dial(t, srv)
+	_, err := io.WriteString(conn, raw)
+	require.NoError(t, err)
+	resp, body := readResponse(t, br)
+	assert.True(t, resp.Close)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	return resp, body
+}
+
+func TestParseErrorResponses(t *testing.T) {
+	srv, err := server.ServeWithOptions(0, text("ok"), server.DefaultOptions())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+		body   string
+	}{
+		{"bad method", "FETCH / HTTP/1.1\r\nHost: localhost\r\n\r\n", 400, request.ErrBadMethod.Error()},
+		{"unsupported version", "GET / HTTP/3.0\r\nHost: localhost\r\n\r\n", 505, request.ErrUnsupportedVersion.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := sendRaw(t, srv, tt.raw)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+			assert.Equal(t, fmt.Sprint(len(body)), resp.Header.Get("Content-Length"))
+			assert.Equal(t, tt.body, body)
+		})
+	}
+}
+
+func TestCustomErrorRenderer(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.ErrorRenderer = func(w *response.Writer, hErr *server.HandlerError) {
+		body := []byte(fmt.Sprintf(`{"status":%d}`, hErr.StatusCode))
+		h := headers.Headers{}
+		h.Set("Content-Type", "application/json")
+		h.Set("Content-Length", fmt.Sprint(len(body)))
+		w.WriteStatusLine(hErr.StatusCode)
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	}
+	srv, err := server.ServeWithOptions(0, text("ok"), opts)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	resp, body := sendRaw(t, srv, "FETCH / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `{"status":400}`, body)
+}
+
+func TestLimitResponses(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Limits = request.Limits{
+		MaxRequestLineBytes: 64,
+		MaxHeaderBytes:      256,
+		MaxBodyBytes:        16,
+	}
+	srv, err := server.ServeWithOptions(0, text("ok"), opts)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+	}{
+		{"request line", "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\nHost: localhost\r\n\r\n", 414},
+		{"headers", "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 300) + "\r\n\r\n", 431},
+		{"body", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 17\r\n\r\n" + strings.Repeat("a", 17), 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := sendRaw(t, srv, tt.raw)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
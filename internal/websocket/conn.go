@@ -0,0 +1,344 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultReadLimit is the largest message a Conn accepts unless the
+// Upgrader or SetReadLimit says otherwise.
+const DefaultReadLimit = 16 << 20
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+	CloseMessage  MessageType = 8
+	PingMessage   MessageType = 9
+	PongMessage   MessageType = 10
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormal             = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatus           = 1005
+	CloseAbnormal           = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+)
+
+var (
+	ErrProtocol       = errors.New("error: websocket protocol violation")
+	ErrReadLimit      = errors.New("error: websocket message exceeds read limit")
+	ErrInvalidPayload = errors.New("error: invalid websocket message payload")
+	ErrCloseSent      = errors.New("error: websocket close already sent")
+)
+
+// deflateTail completes a permessage-deflate message: the sync flush
+// marker the sender stripped, then an empty final block so the reader
+// sees a clean end of stream.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// CloseError is returned by ReadMessage once the peer sent a close frame.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("error: websocket closed with code %d: %s", e.Code, e.Text)
+}
+
+// Conn is a server-side WebSocket connection. One goroutine may read and
+// another may write at the same time; control frames can be written from
+// anywhere.
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	subprotocol string
+	compress    bool
+	readLimit   int64
+	readErr     error
+	pingHandler func(appData []byte) error
+	pongHandler func(appData []byte) error
+	inflater    io.ReadCloser
+
+	wmu       sync.Mutex
+	bw        *bufio.Writer
+	closeSent bool
+	deflater  *flate.Writer
+	deflated  bytes.Buffer
+}
+
+func newConn(conn net.Conn, rw *bufio.ReadWriter, subprotocol string, compress bool, readLimit int64) *Conn {
+	c := &Conn{
+		conn:        conn,
+		br:          rw.Reader,
+		bw:          rw.Writer,
+		subprotocol: subprotocol,
+		compress:    compress,
+		readLimit:   readLimit,
+	}
+	c.pingHandler = func(appData []byte) error {
+		err := c.WriteMessage(PongMessage, appData)
+		if errors.Is(err, ErrCloseSent) {
+			return nil
+		}
+		return err
+	}
+	c.pongHandler = func([]byte) error { return nil }
+	return c
+}
+
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetReadLimit(n int64) {
+	c.readLimit = n
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetPingHandler replaces the default handler, which answers with a pong.
+// Handlers run on the goroutine calling ReadMessage.
+func (c *Conn) SetPingHandler(h func(appData []byte) error) {
+	c.pingHandler = h
+}
+
+func (c *Conn) SetPongHandler(h func(appData []byte) error) {
+	c.pongHandler = h
+}
+
+// ReadMessage returns the next text or binary message, answering pings
+// and reassembling fragments along the way. Once the peer closes, the
+// close is echoed and a *CloseError is returned. A protocol violation
+// sends the matching close code. Every error is permanent.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	typ, p, err := c.readMessage()
+	if err != nil {
+		c.readErr = c.fail(err)
+		return 0, nil, c.readErr
+	}
+	return typ, p, nil
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var compressed bool
+	var msg []byte
+	for {
+		f, err := readFrame(c.br, c.compress, c.readLimit-int64(len(msg)))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch f.opcode {
+		case CloseMessage, PingMessage, PongMessage:
+			if err := c.handleControl(f); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, protocolError("continuation without a message")
+			}
+		default:
+			if typ != 0 {
+				return 0, nil, protocolError("new message before the last one finished")
+			}
+			typ = f.opcode
+			compressed = f.rsv1
+		}
+		msg = append(msg, f.payload...)
+		if f.fin {
+			break
+		}
+	}
+	if compressed {
+		var err error
+		if msg, err = c.inflate(msg); err != nil {
+			return 0, nil, err
+		}
+	}
+	if typ == TextMessage && !utf8.Valid(msg) {
+		return 0, nil, fmt.Errorf("%w: text message is not valid UTF-8", ErrInvalidPayload)
+	}
+	return typ, msg, nil
+}
+
+func (c *Conn) handleControl(f frame) error {
+	switch f.opcode {
+	case PingMessage:
+		return c.pingHandler(f.payload)
+	case PongMessage:
+		return c.pongHandler(f.payload)
+	}
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(f.payload) == 1:
+		return protocolError("close payload of one byte")
+	case len(f.payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(f.payload))
+		closeErr.Text = string(f.payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return protocolError(fmt.Sprintf("invalid close code %d", closeErr.Code))
+		}
+		if !utf8.ValidString(closeErr.Text) {
+			return fmt.Errorf("%w: close reason is not valid UTF-8", ErrInvalidPayload)
+		}
+	}
+	code := closeErr.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	if err := c.WriteClose(code, ""); err != nil && !errors.Is(err, ErrCloseSent) {
+		return err
+	}
+	return closeErr
+}
+
+// fail answers a protocol violation with the matching close code.
+func (c *Conn) fail(err error) error {
+	var code int
+	switch {
+	case errors.Is(err, ErrProtocol):
+		code = CloseProtocolError
+	case errors.Is(err, ErrReadLimit):
+		code = CloseMessageTooBig
+	case errors.Is(err, ErrInvalidPayload):
+		code = CloseInvalidPayload
+	default:
+		return err
+	}
+	c.WriteClose(code, "")
+	return err
+}
+
+// validCloseCode reports whether a peer may send code (RFC 6455 section
+// 7.4): the defined codes that aren't reserved for local use, and the
+// ranges set aside for libraries and applications.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	default:
+		return code >= 3000 && code <= 4999
+	}
+}
+
+func (c *Conn) inflate(p []byte) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail))
+	if c.inflater == nil {
+		c.inflater = flate.NewReader(src)
+	} else if err := c.inflater.(flate.Resetter).Reset(src, nil); err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(c.inflater, c.readLimit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if int64(len(out)) > c.readLimit {
+		return nil, ErrReadLimit
+	}
+	return out, nil
+}
+
+// WriteMessage sends data as a single frame. Text and binary messages are
+// compressed when permessage-deflate was negotiated.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	switch typ {
+	case TextMessage, BinaryMessage:
+	case CloseMessage, PingMessage, PongMessage:
+		if len(data) > maxControlPayload {
+			return protocolError("control frame payload too large")
+		}
+	default:
+		return fmt.Errorf("error: unknown message type %d", typ)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if typ == CloseMessage {
+		c.closeSent = true
+	}
+	rsv1 := false
+	if c.compress && !isControl(typ) {
+		var err error
+		if data, err = c.deflate(data); err != nil {
+			return err
+		}
+		rsv1 = true
+	}
+	if _, err := c.bw.Write(appendFrameHeader(nil, typ, rsv1, len(data))); err != nil {
+		return err
+	}
+	if _, err := c.bw.Write(data); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// WriteClose starts the closing handshake. Keep calling ReadMessage until
+// it returns the peer's *CloseError, then Close the connection.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.WriteMessage(CloseMessage, append(payload, reason...))
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// deflate compresses one message on its own, as no context takeover was
+// negotiated, and strips the trailing sync flush marker (RFC 7692 section
+// 7.2.1). The result is only valid until the next call.
+func (c *Conn) deflate(p []byte) ([]byte, error) {
+	c.deflated.Reset()
+	if c.deflater == nil {
+		w, err := flate.NewWriter(&c.deflated, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		c.deflater = w
+	} else {
+		c.deflater.Reset(&c.deflated)
+	}
+	if _, err := c.deflater.Write(p); err != nil {
+		return nil, err
+	}
+	if err := c.deflater.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(c.deflated.Bytes(), deflateTail[:4]), nil
+}
@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	finBit            = 0x80
+	rsv1Bit           = 0x40
+	rsvOtherBits      = 0x30
+	opcodeMask        = 0x0f
+	maskBit           = 0x80
+	lenMask           = 0x7f
+	len16             = 126
+	len64             = 127
+	maxControlPayload = 125
+)
+
+// opContinuation continues a fragmented text or binary message.
+const opContinuation MessageType = 0
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  MessageType
+	payload []byte
+}
+
+func isControl(op MessageType) bool {
+	return op >= CloseMessage
+}
+
+// readFrame reads one client frame, unmasking its payload. Data frames
+// longer than limit are rejected before their payload is read.
+func readFrame(r io.Reader, compress bool, limit int64) (frame, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		fin:    hdr[0]&finBit != 0,
+		rsv1:   hdr[0]&rsv1Bit != 0,
+		opcode: MessageType(hdr[0] & opcodeMask),
+	}
+	if hdr[0]&rsvOtherBits != 0 {
+		return frame{}, protocolError("reserved bits set")
+	}
+	if hdr[1]&maskBit == 0 {
+		return frame{}, protocolError("client frame not masked")
+	}
+	n := uint64(hdr[1] & lenMask)
+	switch n {
+	case len16:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return frame{}, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case len64:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return frame{}, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+		if n>>63 != 0 {
+			return frame{}, protocolError("payload length has the high bit set")
+		}
+	}
+	switch f.opcode {
+	case opContinuation, TextMessage, BinaryMessage:
+		if f.rsv1 && (!compress || f.opcode == opContinuation) {
+			return frame{}, protocolError("unexpected RSV1 bit")
+		}
+		if n > uint64(limit) {
+			return frame{}, ErrReadLimit
+		}
+	case CloseMessage, PingMessage, PongMessage:
+		if !f.fin || f.rsv1 || n > maxControlPayload {
+			return frame{}, protocolError("invalid control frame")
+		}
+	default:
+		return frame{}, protocolError(fmt.Sprintf("unknown opcode %d", f.opcode))
+	}
+	var key [4]byte
+	if _, err := io.ReadFull(r, key[:]); err != nil {
+		return frame{}, err
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= key[i%4]
+	}
+	return f, nil
+}
+
+// appendFrameHeader encodes an unmasked header, as servers always send.
+func appendFrameHeader(dst []byte, op MessageType, rsv1 bool, n int) []byte {
+	b := finBit | byte(op)
+	if rsv1 {
+		b |= rsv1Bit
+	}
+	dst = append(dst, b)
+	switch {
+	case n < len16:
+		return append(dst, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(dst, len16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(dst, len64), uint64(n))
+	}
+}
+
+func protocolError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrProtocol, reason)
+}
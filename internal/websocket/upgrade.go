@@ -0,0 +1,187 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Jud1k/web_server/internal/headers"
+	"github.com/Jud1k/web_server/internal/request"
+	"github.com/Jud1k/web_server/internal/response"
+)
+
+// acceptGUID is appended to Sec-WebSocket-Key to compute
+// Sec-WebSocket-Accept (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const supportedVersion = "13"
+
+var (
+	ErrBadHandshake = errors.New("error: bad websocket handshake")
+	ErrBadOrigin    = errors.New("error: websocket origin not allowed")
+)
+
+// Upgrader turns HTTP/1.1 requests into WebSocket connections.
+type Upgrader struct {
+	// Subprotocols lists the supported subprotocols in order of preference.
+	Subprotocols []string
+	// CheckOrigin decides whether a browser on another origin may connect.
+	// When nil, the Origin header, if present, must match Host.
+	CheckOrigin func(req *request.Request) bool
+	// EnableCompression accepts the permessage-deflate extension when the
+	// client offers it.
+	EnableCompression bool
+	// ReadLimit caps the size of a received message; 0 means
+	// DefaultReadLimit.
+	ReadLimit int64
+}
+
+// Upgrade performs the opening handshake and takes over the connection.
+// h holds extra headers for the 101 response. On failure an error response
+// has already been written and the handler should simply return.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request, h headers.Headers) (*Conn, error) {
+	key, err := u.checkRequest(w, req)
+	if err != nil {
+		return nil, err
+	}
+	if !w.Hijackable() {
+		response.WriteStatus(w, response.StatusCodeInternalError)
+		return nil, response.ErrNotHijackable
+	}
+	resp := headers.Headers{}
+	for name, values := range h {
+		for _, value := range values {
+			resp.Add(name, value)
+		}
+	}
+	resp.Set("Upgrade", "websocket")
+	resp.Set("Connection", "Upgrade")
+	resp.Set("Sec-WebSocket-Accept", acceptKey(key))
+	subprotocol := u.selectSubprotocol(req)
+	if subprotocol != "" {
+		resp.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	compress := u.EnableCompression && offersDeflate(req.Headers.Values("Sec-WebSocket-Extensions"))
+	if compress {
+		// Without context takeover every message is compressed on its own,
+		// so neither side has to keep a sliding window between messages.
+		resp.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+	if err := w.WriteStatusLine(response.StatusCodeSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(resp); err != nil {
+		return nil, err
+	}
+	conn, rw, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	readLimit := u.ReadLimit
+	if readLimit <= 0 {
+		readLimit = DefaultReadLimit
+	}
+	return newConn(conn, rw, subprotocol, compress, readLimit), nil
+}
+
+// checkRequest validates the client's handshake and returns its key.
+func (u *Upgrader) checkRequest(w *response.Writer, req *request.Request) (string, error) {
+	fail := func(reason string) (string, error) {
+		response.WriteStatus(w, response.StatusCodeBadRequest)
+		return "", fmt.Errorf("%w: %s", ErrBadHandshake, reason)
+	}
+	if req.RequestLine.Method != "GET" {
+		return fail("method must be GET")
+	}
+	if !req.ProtoAtLeast(1, 1) || req.ProtoAtLeast(2, 0) {
+		return fail("HTTP/1.1 required")
+	}
+	if !req.Headers.HasToken("Connection", "upgrade") {
+		return fail("missing Connection: upgrade")
+	}
+	if !req.Headers.HasToken("Upgrade", "websocket") {
+		return fail("missing Upgrade: websocket")
+	}
+	if req.Headers.Get("Sec-WebSocket-Version") != supportedVersion {
+		w.Header().Set("Sec-WebSocket-Version", supportedVersion)
+		response.WriteStatus(w, response.StatusCodeUpgradeRequired)
+		return "", fmt.Errorf("%w: unsupported version %q", ErrBadHandshake, req.Headers.Get("Sec-WebSocket-Version"))
+	}
+	key := strings.TrimSpace(req.Headers.Get("Sec-WebSocket-Key"))
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail("invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		response.WriteStatus(w, response.StatusCodeForbidden)
+		return "", ErrBadOrigin
+	}
+	return key, nil
+}
+
+func (u *Upgrader) selectSubprotocol(req *request.Request) string {
+	var offered []string
+	for _, value := range req.Headers.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(value, ",") {
+			offered = append(offered, strings.TrimSpace(p))
+		}
+	}
+	for _, supported := range u.Subprotocols {
+		for _, p := range offered {
+			if p == supported {
+				return supported
+			}
+		}
+	}
+	return ""
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Headers.Get("Host"))
+}
+
+// offersDeflate reports whether one of the client's extension offers is a
+// permessage-deflate we can accept. compress/flate always uses a 32KB
+// window, so offers that limit the server's window are declined.
+func offersDeflate(values []string) bool {
+	for _, value := range values {
+		for _, offer := range strings.Split(value, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			ok := true
+			for _, param := range params[1:] {
+				name, _, _ := strings.Cut(strings.TrimSpace(param), "=")
+				switch name {
+				case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+				default:
+					ok = false
+				}
+			}
+			if ok {
+				return true
+			}
+		}
+	}
+	return false
+}
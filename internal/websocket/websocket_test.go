@@ -0,0 +1,311 @@
+package websocket_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jud1k/web_server/internal/request"
+	"github.com/Jud1k/web_server/internal/response"
+	"github.com/Jud1k/web_server/internal/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The handshake example from RFC 6455 section 1.3.
+const (
+	testKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	testAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+// serveOne answers a single connection the way the server package does:
+// it reads one request and lets handler hijack the connection. The error
+// returned by Upgrade or by the last ReadMessage is sent on the channel.
+func serveOne(t *testing.T, u *websocket.Upgrader) (net.Conn, <-chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	errc := make(chan error, 1)
+	go func() {
+		nc, err := listener.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		reader := request.NewReader(nc)
+		req, err := reader.ReadRequest()
+		if err != nil {
+			nc.Close()
+			errc <- err
+			return
+		}
+		w := response.NewWriter(nc)
+		w.SetHijacker(func() (net.Conn, io.Reader) {
+			return nc, reader.Detach()
+		})
+		conn, err := u.Upgrade(w, req, nil)
+		if err != nil {
+			w.Finish()
+			nc.Close()
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		for {
+			typ, p, err := conn.ReadMessage()
+			if err != nil {
+				errc <- err
+				return
+			}
+			if err := conn.WriteMessage(typ, p); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client, errc
+}
+
+type testClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func handshake(t *testing.T, conn net.Conn, extra string) (*testClient, *http.Response) {
+	t.Helper()
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n%s\r\n", testKey, extra)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	return &testClient{conn: conn, br: br}, resp
+}
+
+func (c *testClient) writeFrame(t *testing.T, first byte, payload []byte) {
+	t.Helper()
+	buf := []byte{first}
+	switch {
+	case len(payload) < 126:
+		buf = append(buf, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0x80|126), uint16(len(payload)))
+	default:
+		buf = binary.BigEndian.AppendUint64(append(buf, 0x80|127), uint64(len(payload)))
+	}
+	var key [4]byte
+	rand.Read(key[:])
+	buf = append(buf, key[:]...)
+	for i, b := range payload {
+		buf = append(buf, b^key[i%4])
+	}
+	_, err := c.conn.Write(buf)
+	require.NoError(t, err)
+}
+
+// readFrame returns the first header byte and the payload of a server frame.
+func (c *testClient) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var hdr [2]byte
+	_, err := io.ReadFull(c.br, hdr[:])
+	require.NoError(t, err)
+	require.Zero(t, hdr[1]&0x80, "server frames must not be masked")
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, n)
+	_, err = io.ReadFull(c.br, payload)
+	require.NoError(t, err)
+	return hdr[0], payload
+}
+
+func (c *testClient) expectClose(t *testing.T, code int) {
+	t.Helper()
+	first, payload := c.readFrame(t)
+	require.Equal(t, byte(0x88), first)
+	require.GreaterOrEqual(t, len(payload), 2)
+	assert.Equal(t, code, int(binary.BigEndian.Uint16(payload)))
+}
+
+func TestEcho(t *testing.T) {
+	conn, _ := serveOne(t, &websocket.Upgrader{Subprotocols: []string{"chat", "dashboard"}})
+	c, resp := handshake(t, conn, "Sec-WebSocket-Protocol: dashboard, chat\r\n")
+
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, testAccept, resp.Header.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "websocket", resp.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade", resp.Header.Get("Connection"))
+	assert.Equal(t, "chat", resp.Header.Get("Sec-WebSocket-Protocol"))
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	c.writeFrame(t, 0x81, []byte("hello"))
+	first, payload := c.readFrame(t)
+	assert.Equal(t, byte(0x81), first)
+	assert.Equal(t, "hello", string(payload))
+
+	big := bytes.Repeat([]byte("x"), 70000)
+	c.writeFrame(t, 0x82, big)
+	first, payload = c.readFrame(t)
+	assert.Equal(t, byte(0x82), first)
+	assert.Equal(t, big, payload)
+}
+
+func TestFragmentsWithInterleavedPing(t *testing.T) {
+	conn, _ := serveOne(t, &websocket.Upgrader{})
+	c, _ := handshake(t, conn, "")
+
+	c.writeFrame(t, 0x01, []byte("hel"))
+	c.writeFrame(t, 0x89, []byte("are you there"))
+	c.writeFrame(t, 0x80, []byte("lo"))
+
+	first, payload := c.readFrame(t)
+	assert.Equal(t, byte(0x8a), first)
+	assert.Equal(t, "are you there", string(payload))
+	first, payload = c.readFrame(t)
+	assert.Equal(t, byte(0x81), first)
+	assert.Equal(t, "hello", string(payload))
+}
+
+func TestCloseHandshake(t *testing.T) {
+	conn, errc := serveOne(t, &websocket.Upgrader{})
+	c, _ := handshake(t, conn, "")
+
+	c.writeFrame(t, 0x88, append([]byte{0x03, 0xe9}, "bye"...))
+	c.expectClose(t, websocket.CloseGoingAway)
+
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, <-errc, &closeErr)
+	assert.Equal(t, websocket.CloseGoingAway, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Text)
+}
+
+func TestProtocolViolations(t *testing.T) {
+	tests := []struct {
+		name  string
+		send  func(t *testing.T, c *testClient)
+		code  int
+		wants error
+	}{
+		{"unmasked frame", func(t *testing.T, c *testClient) {
+			c.conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+		}, websocket.CloseProtocolError, websocket.ErrProtocol},
+		{"reserved bit", func(t *testing.T, c *testClient) {
+			c.writeFrame(t, 0xc1, []byte("hi"))
+		}, websocket.CloseProtocolError, websocket.ErrProtocol},
+		{"unknown opcode", func(t *testing.T, c *testClient) {
+			c.writeFrame(t, 0x83, nil)
+		}, websocket.CloseProtocolError, websocket.ErrProtocol},
+		{"fragmented ping", func(t *testing.T, c *testClient) {
+			c.writeFrame(t, 0x09, nil)
+		}, websocket.CloseProtocolError, websocket.ErrProtocol},
+		{"continuation without message", func(t *testing.T, c *testClient) {
+			c.writeFrame(t, 0x80, []byte("x"))
+		}, websocket.CloseProtocolError, websocket.ErrProtocol},
+		{"interrupted fragments", func(t *testing.T, c *testClient) {
+			c.writeFrame(t, 0x01, []byte("a"))
+			c.writeFrame(t, 0x81, []byte("b"))
+		}, websocket.CloseProtocolError, websocket.ErrProtocol},
+		{"reserved close code", func(t *testing.T, c *testClient) {
+			c.writeFrame(t, 0x88, []byte{0x03, 0xee})
+		}, websocket.CloseProtocolError, websocket.ErrProtocol},
+		{"invalid utf-8", func(t *testing.T, c *testClient) {
+			c.writeFrame(t, 0x81, []byte{0xff, 0xfe})
+		}, websocket.CloseInvalidPayload, websocket.ErrInvalidPayload},
+		{"message too big", func(t *testing.T, c *testClient) {
+			c.writeFrame(t, 0x02, bytes.Repeat([]byte("x"), 600))
+			c.writeFrame(t, 0x80, bytes.Repeat([]byte("x"), 600))
+		}, websocket.CloseMessageTooBig, websocket.ErrReadLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, errc := serveOne(t, &websocket.Upgrader{ReadLimit: 1024})
+			c, _ := handshake(t, conn, "")
+			tt.send(t, c)
+			c.expectClose(t, tt.code)
+			assert.ErrorIs(t, <-errc, tt.wants)
+		})
+	}
+}
+
+func TestPerMessageDeflate(t *testing.T) {
+	conn, _ := serveOne(t, &websocket.Upgrader{EnableCompression: true})
+	c, resp := handshake(t, conn, "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+		resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	message := strings.Repeat("live dashboard update ", 200)
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+	require.NoError(t, err)
+	fw.Write([]byte(message))
+	fw.Flush()
+	payload := bytes.TrimSuffix(compressed.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+	c.writeFrame(t, 0xc1, payload)
+
+	first, echoed := c.readFrame(t)
+	assert.Equal(t, byte(0xc1), first)
+	assert.Less(t, len(echoed), len(message))
+	inflated, err := io.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(echoed),
+		bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}))))
+	require.NoError(t, err)
+	assert.Equal(t, message, string(inflated))
+}
+
+func TestDeclinesLimitedServerWindow(t *testing.T) {
+	conn, _ := serveOne(t, &websocket.Upgrader{EnableCompression: true})
+	_, resp := handshake(t, conn, "Sec-WebSocket-Extensions: permessage-deflate; server_max_window_bits=10\r\n")
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+}
+
+func TestRejectsBadHandshakes(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+		wants  error
+	}{
+		{"plain get", "GET /ws HTTP/1.1\r\nHost: example.com\r\n\r\n", http.StatusBadRequest, websocket.ErrBadHandshake},
+		{"bad key", "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: short\r\nSec-WebSocket-Version: 13\r\n\r\n", http.StatusBadRequest, websocket.ErrBadHandshake},
+		{"old version", "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 8\r\n\r\n", http.StatusUpgradeRequired, websocket.ErrBadHandshake},
+		{"cross origin", "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\nOrigin: https://evil.test\r\n\r\n", http.StatusForbidden, websocket.ErrBadOrigin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, errc := serveOne(t, &websocket.Upgrader{})
+			io.WriteString(conn, tt.raw)
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.status == http.StatusUpgradeRequired {
+				assert.Equal(t, "13", resp.Header.Get("Sec-WebSocket-Version"))
+			}
+			assert.ErrorIs(t, <-errc, tt.wants)
+		})
+	}
+}